@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
@@ -14,8 +16,31 @@ func Run() {
 	serviceName := flag.String("service-name", "voxlattice", "service name")
 	envPathFlag := flag.String("env", "", "path to .env file")
 	configDirFlag := flag.String("config", ".", "config directory for Voices.json")
+	voicesWatchFlag := flag.Duration("voices-watch", 2*time.Second, "how often Voices.json is checked for changes, 0 only reloads it on SIGHUP")
 	logPathFlag := flag.String("log", "", "log file path")
 	logLevelFlag := flag.String("log-level", "warn", "log level: debug|info|warn|error")
+	logFormatFlag := flag.String("log-format", "text", "log format: text|json")
+	logMaxMBFlag := flag.Int("log-max-mb", defaultLogMaxMB, "log file size in MB at which it is rotated")
+	logMaxBackupsFlag := flag.Int("log-max-backups", defaultLogMaxBackups, "rotated log files to keep")
+	logMaxAgeFlag := flag.Duration("log-max-age", 0, "rotated log files older than this are removed, 0 keeps them")
+	logCompressFlag := flag.Bool("log-compress", true, "gzip rotated log files except the newest")
+	accessLogFlag := flag.Bool("access-log", true, "log every request with its status, size, duration and client")
+	backendFlag := flag.String("backend", "", "synthesis backend: gemini|fake (default: AUDIOMESH_BACKEND or gemini)")
+	cacheDirFlag := flag.String("cache-dir", "", "audio cache directory (default: <config>/cache)")
+	cacheMaxMBFlag := flag.Int("cache-max-mb", 256, "audio cache size limit in MB, 0 disables the cache")
+	cacheTTLFlag := flag.Duration("cache-ttl", 30*24*time.Hour, "audio cache entry lifetime, 0 keeps entries until evicted")
+	werThresholdFlag := flag.Float64("wer-threshold", 0, "transcript word error rate above which a chunk is retried or the request fails, 0 only reports it")
+	werRetriesFlag := flag.Int("wer-retries", 1, "times a chunk above --wer-threshold is synthesized again before failing")
+	jobWorkersFlag := flag.Int("job-workers", defaultJobWorkers, "number of /jobs items synthesized at the same time")
+	jobRetentionFlag := flag.Duration("job-retention", defaultJobRetention, "how long finished jobs and their results are kept, 0 keeps them until deleted")
+	keysFileFlag := flag.String("keys-file", "", "client API keys file (default: <config>/Keys.json)")
+	addKeyFlag := flag.String("add-key", "", "create a client API key with this name in the keys file, print it and exit")
+	allowGeminiKeysFlag := flag.Bool("allow-gemini-keys", true, "let callers send their own Gemini API key instead of a client key")
+	rateRPMFlag := flag.Int("rate-rpm", 0, "requests per minute allowed per client without limits of its own, 0 for no limit")
+	rateConcurrentFlag := flag.Int("rate-concurrent", 0, "requests in progress allowed per client without limits of its own, 0 for no limit")
+	quotaDailyFlag := flag.Int64("quota-daily-chars", 0, "characters per UTC day allowed per client without limits of its own, 0 for no limit")
+	quotaMonthlyFlag := flag.Int64("quota-monthly-chars", 0, "characters per UTC month allowed per client without limits of its own, 0 for no limit")
+	otlpEndpointFlag := flag.String("otlp-endpoint", "", "OTLP/HTTP collector to export traces to, e.g. http://localhost:4318 (default: OTEL_EXPORTER_OTLP_ENDPOINT, off when unset)")
 	flag.Parse()
 
 	if *install && *uninstall {
@@ -28,14 +53,40 @@ func Run() {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(2)
 	}
-	if err := initLogger(*logPathFlag, level); err != nil {
+	logJSON, err := parseLogFormat(*logFormatFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	rotation := logRotation{
+		maxBytes:   int64(*logMaxMBFlag) * 1024 * 1024,
+		maxBackups: *logMaxBackupsFlag,
+		maxAge:     *logMaxAgeFlag,
+		compress:   *logCompressFlag,
+	}
+	if err := rotation.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, "--log-max-*:", err)
+		os.Exit(2)
+	}
+	logAccess = *accessLogFlag
+	if err := initLogger(*logPathFlag, level, logJSON, rotation); err != nil {
 		fmt.Fprintln(os.Stderr, "init logger failed:", err)
 		os.Exit(2)
 	}
 
+	keysPath := keysFilePath(*keysFileFlag, *configDirFlag)
+	if *addKeyFlag != "" {
+		key, err := addClientKey(keysPath, *addKeyFlag)
+		if err != nil {
+			appLog.Fatalf("add key failed: %v", err)
+		}
+		fmt.Printf("Client key %q added to %s, it will not be shown again:\n%s\n", *addKeyFlag, keysPath, key)
+		return
+	}
+
 	if *install {
 		envPath := resolveInstallEnvPath(*envPathFlag, *serviceName)
-		args := buildServiceArgs(*logPathFlag, level.String(), *configDirFlag)
+		args := buildServiceArgs(*logPathFlag, level.String(), *configDirFlag, serviceFlagArgs())
 		if err := installService(*serviceName, envPath, args); err != nil {
 			appLog.Fatalf("install failed: %v", err)
 		}
@@ -59,23 +110,115 @@ func Run() {
 		"GEMINI_MODEL":   defaultModel,
 		"AUDIOMESH_PORT": "8080",
 	})
+	if err := selectBackend(resolveBackendName(*backendFlag)); err != nil {
+		appLog.Fatalf("%v", err)
+	}
+	appLog.Infof("Synthesis backend: %s", activeBackendName)
+
+	tracing, err := initTracing(*otlpEndpointFlag, *serviceName)
+	if err != nil {
+		appLog.Fatalf("init tracing failed: %v", err)
+	}
+	if tracing {
+		appLog.Infof("Tracing: exporting spans over OTLP/HTTP")
+	}
+
 	voices, source, err := loadSupportedVoices(*configDirFlag)
 	if err != nil {
 		appLog.Fatalf("load voices failed: %v", err)
 	}
-	supportedVoices = voices
+	supportedVoices.Store(&voices)
 	appLog.Infof("Voices loaded from: %s", source)
 
-	http.HandleFunc("/tts", ttsHandler)
-	http.HandleFunc("/health", healthHandler)
-	http.HandleFunc("/voices", voicesHandler)
+	keys, err := loadClientKeys(keysPath)
+	if err != nil {
+		appLog.Fatalf("load client keys failed: %v", err)
+	}
+	clientKeys = keys
+	allowGeminiKeys = *allowGeminiKeysFlag
+	appLog.Infof("Client keys: %d from %s", len(clientKeys), keysPath)
+	if len(clientKeys) == 0 && activeBackend.requiresAPIKey() {
+		if allowGeminiKeys {
+			appLog.Warnf("No client keys in %s, only callers with their own Gemini key can synthesize (add one with --add-key)", keysPath)
+		} else {
+			appLog.Warnf("No client keys in %s and --allow-gemini-keys=false, every request will be refused (add one with --add-key)", keysPath)
+		}
+	}
+
+	defaultLimits = clientLimits{
+		RequestsPerMinute: *rateRPMFlag,
+		Concurrent:        *rateConcurrentFlag,
+		DailyChars:        *quotaDailyFlag,
+		MonthlyChars:      *quotaMonthlyFlag,
+	}
+	if err := defaultLimits.validate(); err != nil {
+		appLog.Fatalf("--rate-* and --quota-*: %v", err)
+	}
+	usagePath := resolveUsagePath(*configDirFlag)
+	tracker, err := newUsageTracker(usagePath)
+	if err != nil {
+		appLog.Fatalf("open usage counters failed: %v", err)
+	}
+	usage = tracker
+	usage.start()
+	appLog.Infof("Usage counters: %s (default limits %+v)", usagePath, defaultLimits)
+
+	if *werThresholdFlag < 0 || *werRetriesFlag < 0 {
+		appLog.Fatalf("--wer-threshold and --wer-retries must not be negative")
+	}
+	fidelityThreshold = *werThresholdFlag
+	fidelityRetries = *werRetriesFlag
+	if fidelityThreshold > 0 {
+		appLog.Infof("Transcript check: word error rate above %.2f retried %d times", fidelityThreshold, fidelityRetries)
+	}
+
+	if *cacheMaxMBFlag > 0 {
+		cacheDir := resolveCacheDir(*cacheDirFlag, *configDirFlag)
+		cache, err := newDiskCache(cacheDir, int64(*cacheMaxMBFlag)*1024*1024, *cacheTTLFlag)
+		if err != nil {
+			appLog.Fatalf("open cache failed: %v", err)
+		}
+		audioCache = cache
+		appLog.Infof("Audio cache: %s (%d MB, ttl %s)", cacheDir, *cacheMaxMBFlag, *cacheTTLFlag)
+	}
+
+	if *jobWorkersFlag < 1 {
+		appLog.Fatalf("--job-workers must be at least 1")
+	}
+	jobsDir := resolveJobsDir(*configDirFlag)
+	jobs, err := newJobManager(jobsDir)
+	if err != nil {
+		appLog.Fatalf("open job store failed: %v", err)
+	}
+	jobQueue = jobs
+	jobQueue.start(*jobWorkersFlag, *jobRetentionFlag)
+	appLog.Infof("Job store: %s (%d workers)", jobsDir, *jobWorkersFlag)
+
+	onHangup(reopenLogFile)
+	voicesPath := voicesFilePath(*configDirFlag)
+	onHangup(func() { reloadVoices(voicesPath) })
+	if *voicesWatchFlag > 0 {
+		watchVoicesFile(voicesPath, *voicesWatchFlag)
+	}
+
+	http.HandleFunc("/tts", instrument("/tts", ttsHandler))
+	http.HandleFunc("/tts/dialogue", instrument("/tts/dialogue", dialogueHandler))
+	http.HandleFunc("/tts/stream", instrument("/tts/stream", ttsStreamHandler))
+	http.HandleFunc("/jobs", instrument("/jobs", jobsHandler))
+	http.HandleFunc("/jobs/", instrument("/jobs/{id}", jobHandler))
+	http.HandleFunc("/health", instrument("/health", healthHandler))
+	http.HandleFunc("/voices", instrument("/voices", voicesHandler))
+	http.HandleFunc("/v1/audio/speech", instrument("/v1/audio/speech", openAISpeechHandler))
+	http.HandleFunc("/v1/text:synthesize", instrument("/v1/text:synthesize", cloudSynthesizeHandler))
+	http.HandleFunc("/v1/voices", instrument("/v1/voices", cloudVoicesHandler))
+	http.HandleFunc("/metrics", metricsHandler)
 
 	addr, err := getListenAddr()
 	if err != nil {
 		appLog.Fatalf("invalid port: %v", err)
 	}
 	fmt.Printf("Voxlattice starting on %s\n", addr)
-	fmt.Printf("Voices loaded: %d\n", len(supportedVoices))
+	fmt.Printf("Voices loaded: %d\n", len(currentVoices()))
 	appLog.Infof("TTS service starting on %s", addr)
 	appLog.Infof("Supported voices: %v", getSupportedVoiceNames())
 	server := &http.Server{
@@ -89,3 +232,15 @@ func Run() {
 		appLog.Fatalf("server failed: %v", err)
 	}
 }
+
+// onHangup calls fn every time the process receives SIGHUP, which Windows
+// never sends.
+func onHangup(fn func()) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	go func() {
+		for range ch {
+			fn()
+		}
+	}()
+}
@@ -0,0 +1,274 @@
+package voxlattice
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Our own API keys start with this prefix; any other token a caller sends
+// is taken for a Gemini key.
+const clientKeyPrefix = "vx_"
+
+// Client keys from Keys.json, by hash (nil until Run loads them)
+var clientKeys map[string]*clientKey
+
+// allowGeminiKeys lets callers pay with their own Gemini key (--allow-gemini-keys).
+var allowGeminiKeys = true
+
+// clientKey is one entry of Keys.json. Only the SHA-256 of the key is
+// stored; empty Voices or Models allow all of them, and without Limits
+// the server-wide defaults apply.
+type clientKey struct {
+	Name    string        `json:"name"`
+	Hash    string        `json:"hash"` // "sha256:<hex>"
+	Enabled bool          `json:"enabled"`
+	Voices  []string      `json:"voices,omitempty"`
+	Models  []string      `json:"models,omitempty"`
+	Limits  *clientLimits `json:"limits,omitempty"`
+}
+
+type keysFile struct {
+	Keys []*clientKey `json:"keys"`
+}
+
+// callerAuth is who made a request and which Gemini key pays for it.
+type callerAuth struct {
+	apiKey string     // empty when the backend needs no key
+	client *clientKey // nil when no client key was presented
+	own    bool       // apiKey came from the caller, not GEMINI_API_KEY
+}
+
+// authError is a request the caller is not allowed to make.
+type authError struct {
+	status  int
+	message string
+}
+
+func (e *authError) Error() string { return e.message }
+
+func unauthorized(message string) error {
+	return &authError{status: http.StatusUnauthorized, message: message}
+}
+
+func forbidden(message string) error {
+	return &authError{status: http.StatusForbidden, message: message}
+}
+
+// authErrorStatus is the HTTP status for an error from resolveAPIKey or
+// checkAccess.
+func authErrorStatus(err error) int {
+	var authErr *authError
+	if errors.As(err, &authErr) {
+		return authErr.status
+	}
+	return http.StatusUnauthorized
+}
+
+func keysFilePath(flagVal, configDir string) string {
+	if flagVal != "" {
+		return flagVal
+	}
+	dir := strings.TrimSpace(configDir)
+	if dir == "" || dir == "." {
+		return "Keys.json"
+	}
+	return filepath.Join(dir, "Keys.json")
+}
+
+func hashClientKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func readKeysFile(path string) (keysFile, error) {
+	var f keysFile
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return f, err
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return f, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// loadClientKeys reads the keys file, a missing file meaning no keys.
+// Voice names are lowercased and models canonicalized like requests are.
+func loadClientKeys(path string) (map[string]*clientKey, error) {
+	f, err := readKeysFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]*clientKey, len(f.Keys))
+	for i, k := range f.Keys {
+		if k == nil || strings.TrimSpace(k.Name) == "" || !strings.HasPrefix(k.Hash, "sha256:") {
+			return nil, fmt.Errorf("%s: key %d needs a name and a sha256 hash", path, i)
+		}
+		if _, dup := keys[k.Hash]; dup {
+			return nil, fmt.Errorf("%s: key %q is listed twice", path, k.Name)
+		}
+		if k.Limits != nil {
+			if err := k.Limits.validate(); err != nil {
+				return nil, fmt.Errorf("%s: key %q: %w", path, k.Name, err)
+			}
+		}
+		for j, v := range k.Voices {
+			k.Voices[j] = strings.ToLower(strings.TrimSpace(v))
+		}
+		for j, m := range k.Models {
+			k.Models[j] = canonicalModelName(strings.TrimSpace(m))
+		}
+		keys[k.Hash] = k
+	}
+	return keys, nil
+}
+
+// addClientKey creates a key called name in the keys file and returns it.
+// The key itself is shown only this once.
+func addClientKey(path, name string) (string, error) {
+	name = strings.TrimSpace(name)
+	f, err := readKeysFile(path)
+	if err != nil {
+		return "", err
+	}
+	for _, k := range f.Keys {
+		if k != nil && k.Name == name {
+			return "", fmt.Errorf("key %q already exists in %s", name, path)
+		}
+	}
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	key := clientKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	f.Keys = append(f.Keys, &clientKey{Name: name, Hash: hashClientKey(key), Enabled: true})
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(path, append(data, '\n')); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// requestTokens returns the credentials a request carries, in order of
+// precedence; extra holds endpoint specific sources such as ?key=.
+func requestTokens(r *http.Request, extra ...string) []string {
+	var tokens []string
+	add := func(v string) {
+		if v = strings.TrimSpace(v); v != "" {
+			tokens = append(tokens, v)
+		}
+	}
+	if r != nil {
+		add(r.Header.Get("X-Gemini-Api-Key"))
+		add(r.Header.Get("X-API-Key"))
+		auth := strings.TrimSpace(r.Header.Get("Authorization"))
+		if len(auth) >= 7 && strings.EqualFold(auth[:7], "Bearer ") {
+			add(auth[7:])
+		}
+	}
+	for _, v := range extra {
+		add(v)
+	}
+	return tokens
+}
+
+// resolveAPIKey authenticates r. A client key identifies the caller; a
+// Gemini key of the caller's own is used as is when passthrough is on.
+// GEMINI_API_KEY is only spent on behalf of a valid client key.
+func resolveAPIKey(r *http.Request, extra ...string) (callerAuth, error) {
+	var auth callerAuth
+	var geminiKey string
+	for _, token := range requestTokens(r, extra...) {
+		if strings.HasPrefix(token, clientKeyPrefix) {
+			if auth.client == nil {
+				k, ok := clientKeys[hashClientKey(token)]
+				if !ok {
+					return auth, unauthorized("invalid api key")
+				}
+				if !k.Enabled {
+					return auth, forbidden("api key disabled")
+				}
+				auth.client = k
+				noteClient(r, k.Name)
+			}
+		} else if geminiKey == "" {
+			geminiKey = token
+		}
+	}
+
+	if geminiKey != "" {
+		if !allowGeminiKeys {
+			return auth, forbidden("gemini api keys are not accepted, use a client key")
+		}
+		auth.apiKey, auth.own = geminiKey, true
+		return auth, nil
+	}
+	if !activeBackend.requiresAPIKey() {
+		return auth, nil
+	}
+	if auth.client == nil {
+		return auth, unauthorized("missing api key")
+	}
+	apiKey, ok := serverAPIKey()
+	if !ok {
+		return auth, unauthorized("missing api key")
+	}
+	auth.apiKey = apiKey
+	return auth, nil
+}
+
+// serverAPIKey returns GEMINI_API_KEY unless it is unset or the placeholder.
+func serverAPIKey() (string, bool) {
+	apiKey := strings.TrimSpace(os.Getenv("GEMINI_API_KEY"))
+	if apiKey == "" || apiKey == "your_api_key_here" {
+		return "", false
+	}
+	return apiKey, true
+}
+
+// checkAccess enforces a client key's voice and model lists. An empty voice
+// leaves the choice to the model, so a key limited to some voices must name
+// one of them.
+func (a callerAuth) checkAccess(model string, voices ...string) error {
+	if a.client == nil {
+		return nil
+	}
+	if len(a.client.Models) > 0 && !slices.Contains(a.client.Models, model) {
+		return forbidden(fmt.Sprintf("model %s is not allowed for this api key", strings.TrimPrefix(model, "models/")))
+	}
+	if len(a.client.Voices) > 0 {
+		for _, v := range voices {
+			if v == "" {
+				return forbidden(fmt.Sprintf("this api key requires a voice, allowed voices: %v", a.client.Voices))
+			}
+			if !slices.Contains(a.client.Voices, v) {
+				return forbidden(fmt.Sprintf("voice %s is not allowed for this api key", v))
+			}
+		}
+	}
+	return nil
+}
+
+// clientName names the caller in logs.
+func (a callerAuth) clientName() string {
+	if a.client == nil {
+		return "-"
+	}
+	return a.client.Name
+}
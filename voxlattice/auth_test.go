@@ -0,0 +1,58 @@
+package voxlattice
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCheckAccess(t *testing.T) {
+	limited := &clientKey{Name: "limited", Enabled: true, Voices: []string{"kore"}, Models: []string{"models/a"}}
+	open := &clientKey{Name: "open", Enabled: true}
+
+	tests := []struct {
+		name   string
+		client *clientKey
+		model  string
+		voices []string
+		ok     bool
+	}{
+		{"no client key", nil, "models/b", []string{""}, true},
+		{"unrestricted", open, "models/b", []string{"", "charon"}, true},
+		{"allowed", limited, "models/a", []string{"kore"}, true},
+		{"model", limited, "models/b", []string{"kore"}, false},
+		{"voice", limited, "models/a", []string{"charon"}, false},
+		{"one of several", limited, "models/a", []string{"kore", "charon"}, false},
+		{"default voice", limited, "models/a", []string{""}, false},
+		{"no voices", limited, "models/a", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := callerAuth{client: tt.client}.checkAccess(tt.model, tt.voices...)
+			if (err == nil) != tt.ok {
+				t.Fatalf("checkAccess(%s, %q) = %v, want ok %v", tt.model, tt.voices, err, tt.ok)
+			}
+			if err != nil && authErrorStatus(err) != http.StatusForbidden {
+				t.Errorf("status %d, want %d", authErrorStatus(err), http.StatusForbidden)
+			}
+		})
+	}
+}
+
+func TestPreflightAllowsKeyHeaders(t *testing.T) {
+	handlers := map[string]http.HandlerFunc{
+		"/tts":          ttsHandler,
+		"/tts/dialogue": dialogueHandler,
+		"/jobs":         jobsHandler,
+		"/jobs/x":       jobHandler,
+	}
+	for target, handler := range handlers {
+		w := serve(handler, http.MethodOptions, target, "")
+		allowed := strings.ToLower(w.Header().Get("Access-Control-Allow-Headers"))
+		for _, h := range []string{"authorization", "x-api-key", "x-gemini-api-key"} {
+			if !strings.Contains(allowed, h) {
+				t.Errorf("%s preflight allows %q, missing %s", target, allowed, h)
+			}
+		}
+	}
+}
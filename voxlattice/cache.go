@@ -0,0 +1,330 @@
+package voxlattice
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	cacheFileExt = ".audio"
+	cacheMagic   = "VXC1"
+	// Magic plus the creation time in unix nanoseconds.
+	cacheHeaderLen = len(cacheMagic) + 8
+)
+
+// Synthesized audio cache (nil when disabled)
+var audioCache *diskCache
+
+// diskCache stores encoded audio under a content-addressed file name. File
+// modification times track last access so LRU order survives restarts, and
+// each file starts with its creation time for TTL expiry. Files are written
+// to a temp file and renamed into place, so concurrent writers (including
+// other processes sharing the directory) never expose partial entries.
+type diskCache struct {
+	dir      string
+	maxBytes int64
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	lru     *list.List // front is most recently used
+	entries map[string]*list.Element
+	size    int64
+}
+
+type cacheEntry struct {
+	key  string
+	size int64
+}
+
+// cacheKeyInput lists everything that changes the synthesized bytes.
+type cacheKeyInput struct {
+	Version      int    `json:"v"`
+	Backend      string `json:"backend"`
+	Text         string `json:"text"`
+	SSML         string `json:"ssml,omitempty"`
+	Voice        string `json:"voice"`
+	Lang         string `json:"lang"`
+	Model        string `json:"model"`
+	Format       string `json:"format"`
+	SampleRate   int    `json:"sample_rate"`
+	Bitrate      int    `json:"bitrate"`
+	ChunkPauseMs int    `json:"chunk_pause_ms"`
+	// Omitted at normal speed so existing entries stay valid
+	Speed float64 `json:"speed,omitempty"`
+	// Word timing format, alone or next to the audio
+	Timing string `json:"timing,omitempty"`
+	// Dialogue requests only
+	Turns    []dialogueTurn    `json:"turns,omitempty"`
+	Speakers map[string]string `json:"speakers,omitempty"`
+	GapMs    int               `json:"gap_ms,omitempty"`
+	Native   bool              `json:"native,omitempty"`
+}
+
+func synthesisKey(req ttsReq, model string, format audioFormat, opts encodeOptions, pauseMs int) string {
+	data, _ := json.Marshal(cacheKeyInput{
+		Version:      1,
+		Backend:      activeBackendName,
+		Text:         req.Text,
+		SSML:         req.SSML,
+		Voice:        req.Voice,
+		Lang:         req.Lang,
+		Model:        model,
+		Format:       format.name,
+		SampleRate:   opts.sampleRate,
+		Bitrate:      opts.bitrateKbps,
+		ChunkPauseMs: pauseMs,
+		Speed:        cacheSpeed(req.Speed),
+		Timing:       req.TimingFormat,
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func cacheSpeed(speed float64) float64 {
+	if speed == 1 {
+		return 0
+	}
+	return speed
+}
+
+// dialogueKey leaves gapMs out in native mode, where the model sets the
+// pauses and gap_ms has no effect.
+func dialogueKey(req dialogueRequest, format audioFormat, opts encodeOptions, native bool, gapMs int) string {
+	if native {
+		gapMs = 0
+	}
+	data, _ := json.Marshal(cacheKeyInput{
+		Version:      1,
+		Backend:      activeBackendName,
+		Lang:         req.Lang,
+		Model:        req.Model,
+		Format:       format.name,
+		SampleRate:   opts.sampleRate,
+		Bitrate:      opts.bitrateKbps,
+		ChunkPauseMs: defaultChunkPauseMs,
+		Turns:        req.Turns,
+		Speakers:     req.Voices,
+		GapMs:        gapMs,
+		Native:       native,
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func resolveCacheDir(flagVal, configDir string) string {
+	if flagVal != "" {
+		return flagVal
+	}
+	dir := strings.TrimSpace(configDir)
+	if dir == "" || dir == "." {
+		return "cache"
+	}
+	return filepath.Join(dir, "cache")
+}
+
+// newDiskCache opens dir, indexing existing entries from oldest to newest
+// access and evicting anything expired or over the size cap.
+func newDiskCache(dir string, maxBytes int64, ttl time.Duration) (*diskCache, error) {
+	if maxBytes <= 0 {
+		return nil, errors.New("cache size must be positive")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &diskCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		lru:      list.New(),
+		entries:  map[string]*list.Element{},
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type found struct {
+		key     string
+		size    int64
+		modTime time.Time
+	}
+	var existing []found
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		// Leftovers from writers that died before renaming.
+		if strings.HasSuffix(name, ".tmp") && time.Since(info.ModTime()) > time.Hour {
+			_ = os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		if !strings.HasSuffix(name, cacheFileExt) {
+			continue
+		}
+		key := strings.TrimSuffix(name, cacheFileExt)
+		if c.expired(key) {
+			_ = os.Remove(c.path(key))
+			continue
+		}
+		existing = append(existing, found{key, info.Size(), info.ModTime()})
+	}
+	sort.Slice(existing, func(i, j int) bool { return existing[i].modTime.Before(existing[j].modTime) })
+	for _, f := range existing {
+		c.entries[f.key] = c.lru.PushFront(&cacheEntry{key: f.key, size: f.size})
+		c.size += f.size
+	}
+	c.evictLocked()
+	return c, nil
+}
+
+func (c *diskCache) path(key string) string {
+	return filepath.Join(c.dir, key+cacheFileExt)
+}
+
+// expired reads just the header of an entry; unreadable entries count as expired.
+func (c *diskCache) expired(key string) bool {
+	f, err := os.Open(c.path(key))
+	if err != nil {
+		return true
+	}
+	defer f.Close()
+	header := make([]byte, cacheHeaderLen)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return true
+	}
+	created, ok := parseCacheHeader(header)
+	return !ok || (c.ttl > 0 && time.Since(created) > c.ttl)
+}
+
+func parseCacheHeader(data []byte) (time.Time, bool) {
+	if len(data) < cacheHeaderLen || string(data[:len(cacheMagic)]) != cacheMagic {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(data[len(cacheMagic):]))), true
+}
+
+// Get returns the cached audio for key, dropping it if it has expired.
+func (c *diskCache) Get(key string) ([]byte, bool) {
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			appLog.Warnf("cache read failed: %v", err)
+		}
+		c.forget(key)
+		return nil, false
+	}
+	created, ok := parseCacheHeader(data)
+	if !ok {
+		appLog.Warnf("cache entry corrupt, removing: %s", key)
+		c.remove(key)
+		return nil, false
+	}
+	if c.ttl > 0 && time.Since(created) > c.ttl {
+		c.remove(key)
+		return nil, false
+	}
+
+	now := time.Now()
+	_ = os.Chtimes(c.path(key), now, now)
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(el)
+	} else {
+		// Written by another process sharing the directory.
+		c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, size: int64(len(data))})
+		c.size += int64(len(data))
+		c.evictLocked()
+	}
+	c.mu.Unlock()
+	return data[cacheHeaderLen:], true
+}
+
+// Put stores audio under key, evicting least recently used entries as needed.
+func (c *diskCache) Put(key string, audio []byte) error {
+	size := int64(cacheHeaderLen + len(audio))
+	if size > c.maxBytes {
+		return fmt.Errorf("entry of %d bytes exceeds cache size", size)
+	}
+	tmp, err := os.CreateTemp(c.dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	header := make([]byte, cacheHeaderLen)
+	copy(header, cacheMagic)
+	binary.BigEndian.PutUint64(header[len(cacheMagic):], uint64(time.Now().UnixNano()))
+	_, err = tmp.Write(header)
+	if err == nil {
+		_, err = tmp.Write(audio)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path(key))
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*cacheEntry)
+		c.size += size - entry.size
+		entry.size = size
+		c.lru.MoveToFront(el)
+	} else {
+		c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, size: size})
+		c.size += size
+	}
+	c.evictLocked()
+	return nil
+}
+
+func (c *diskCache) remove(key string) {
+	if err := os.Remove(c.path(key)); err != nil && !os.IsNotExist(err) {
+		appLog.Warnf("cache remove failed: %v", err)
+	}
+	c.forget(key)
+}
+
+func (c *diskCache) forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.size -= el.Value.(*cacheEntry).size
+		c.lru.Remove(el)
+		delete(c.entries, key)
+	}
+}
+
+func (c *diskCache) evictLocked() {
+	for c.size > c.maxBytes && c.lru.Len() > 0 {
+		el := c.lru.Back()
+		entry := el.Value.(*cacheEntry)
+		if err := os.Remove(c.path(entry.key)); err != nil && !os.IsNotExist(err) {
+			appLog.Warnf("cache evict failed: %v", err)
+		}
+		c.size -= entry.size
+		c.lru.Remove(el)
+		delete(c.entries, entry.key)
+	}
+}
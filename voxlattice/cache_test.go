@@ -0,0 +1,115 @@
+package voxlattice
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useCache enables an empty audio cache for the rest of the test.
+func useCache(t *testing.T) *diskCache {
+	t.Helper()
+	saved := audioCache
+	t.Cleanup(func() { audioCache = saved })
+	c, err := newDiskCache(t.TempDir(), 1<<20, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audioCache = c
+	return c
+}
+
+func newTestCache(t *testing.T, maxBytes int64, ttl time.Duration) *diskCache {
+	t.Helper()
+	c, err := newDiskCache(t.TempDir(), maxBytes, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestDiskCacheRoundTrip(t *testing.T) {
+	c := newTestCache(t, 1<<20, 0)
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("empty cache returned an entry")
+	}
+	if err := c.Put("a", []byte("audio")); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := c.Get("a"); !ok || string(got) != "audio" {
+		t.Errorf("Get = %q, %v, want audio", got, ok)
+	}
+
+	// A second cache on the same directory picks the entry up.
+	c2, err := newDiskCache(c.dir, 1<<20, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := c2.Get("a"); !ok || string(got) != "audio" {
+		t.Errorf("reopened Get = %q, %v, want audio", got, ok)
+	}
+}
+
+func TestDiskCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	entry := []byte(strings.Repeat("x", 10))
+	c := newTestCache(t, 3*int64(cacheHeaderLen+len(entry)), 0)
+	for _, key := range []string{"a", "b", "c"} {
+		if err := c.Put(key, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("a evicted before the cache was full")
+	}
+	if err := c.Put("d", entry); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true, "d": true} {
+		if _, ok := c.Get(key); ok != want {
+			t.Errorf("Get(%s) found = %v, want %v", key, ok, want)
+		}
+	}
+	if _, err := os.Stat(c.path("b")); !os.IsNotExist(err) {
+		t.Errorf("evicted entry still on disk: %v", err)
+	}
+	if err := c.Put("big", make([]byte, c.maxBytes)); err == nil {
+		t.Error("Put accepted an entry larger than the cache")
+	}
+}
+
+func TestDiskCacheExpires(t *testing.T) {
+	c := newTestCache(t, 1<<20, 20*time.Millisecond)
+	if err := c.Put("a", []byte("audio")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("fresh entry missing")
+	}
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := c.Get("a"); ok {
+		t.Error("expired entry returned")
+	}
+	if _, err := os.Stat(c.path("a")); !os.IsNotExist(err) {
+		t.Errorf("expired entry still on disk: %v", err)
+	}
+}
+
+func TestDiskCacheRemovesCorruptEntry(t *testing.T) {
+	c := newTestCache(t, 1<<20, 0)
+	if err := c.Put("a", []byte("audio")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(c.path("a"), []byte("garbage"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Error("corrupt entry returned")
+	}
+	if _, err := os.Stat(c.path("a")); !os.IsNotExist(err) {
+		t.Errorf("corrupt entry still on disk: %v", err)
+	}
+	if c.size != 0 || c.lru.Len() != 0 {
+		t.Errorf("index still holds %d bytes in %d entries", c.size, c.lru.Len())
+	}
+}
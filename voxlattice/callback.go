@@ -0,0 +1,269 @@
+package voxlattice
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// Eight attempts spaced 2s, 4s, ... 128s apart span about four minutes.
+	callbackAttempts  = 8
+	callbackBaseDelay = 2 * time.Second
+	callbackMaxDelay  = 5 * time.Minute
+	callbackTimeout   = 10 * time.Second
+	deadLetterFile    = "dead-letters.jsonl"
+)
+
+// Callback delivery states kept on the job
+const (
+	callbackPending   = "pending"
+	callbackDelivered = "delivered"
+	callbackDead      = "dead"
+)
+
+// callbackClient checks every address it connects to, redirects included,
+// so a receiver name that resolves to an internal host is refused too. It
+// ignores proxy settings, which would hide the address.
+var callbackClient = &http.Client{
+	Timeout: callbackTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: callbackTimeout,
+			Control: func(_, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !callbackIPAllowed(ip) {
+					return fmt.Errorf("callback address %s is not allowed", host)
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: callbackTimeout,
+	},
+}
+
+// Address ranges that are not on the public internet and may not receive
+// callbacks unless listed in AUDIOMESH_CALLBACK_ALLOW
+var callbackBlockedNets = parseCIDRs(
+	"0.0.0.0/8",     // "this" network
+	"100.64.0.0/10", // carrier-grade NAT
+	"192.0.0.0/24",  // IETF protocol assignments
+	"198.18.0.0/15", // benchmarking
+	"240.0.0.0/4",   // reserved
+	"64:ff9b::/96",  // NAT64, maps to IPv4
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(strings.TrimSpace(c))
+		if err != nil {
+			appLog.Warnf("ignoring invalid network %q: %v", c, err)
+			continue
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// callbackIPAllowed refuses loopback, private, link-local (cloud metadata
+// included) and other non-public addresses, except in the networks the
+// operator lists in AUDIOMESH_CALLBACK_ALLOW, e.g. "10.1.0.0/16".
+func callbackIPAllowed(ip net.IP) bool {
+	if v := strings.TrimSpace(os.Getenv("AUDIOMESH_CALLBACK_ALLOW")); v != "" {
+		for _, n := range parseCIDRs(strings.Split(v, ",")...) {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, n := range callbackBlockedNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// deadLetterMu serializes appends to the dead-letter log.
+var deadLetterMu sync.Mutex
+
+func callbackSecret() string {
+	return strings.TrimSpace(os.Getenv("AUDIOMESH_CALLBACK_SECRET"))
+}
+
+// validateCallbackURL accepts absolute http(s) URLs, and only when there is
+// a secret to sign the notifications with. Hosts given as a non-public
+// address or as localhost are refused here; names that resolve to one are
+// refused by callbackClient when it connects.
+func validateCallbackURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("invalid callback_url: %s", raw)
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if ip := net.ParseIP(host); (ip != nil && !callbackIPAllowed(ip)) || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("callback_url host is not allowed: %s", u.Hostname())
+	}
+	if callbackSecret() == "" {
+		return errors.New("callback_url needs AUDIOMESH_CALLBACK_SECRET to be set on the server")
+	}
+	if publicBaseURL() == "" {
+		return errors.New("callback_url needs AUDIOMESH_PUBLIC_URL to be set on the server")
+	}
+	return nil
+}
+
+// publicBaseURL is where callback receivers can reach this server, from
+// AUDIOMESH_PUBLIC_URL. The request's own Host is not trusted for links
+// the server signs.
+func publicBaseURL() string {
+	return strings.TrimRight(strings.TrimSpace(os.Getenv("AUDIOMESH_PUBLIC_URL")), "/")
+}
+
+// signCallback returns the hex HMAC-SHA256 of "<timestamp>.<body>".
+func signCallback(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// callbackPayloadFor describes finished job j.
+func callbackPayloadFor(j *jobRecord) callbackPayload {
+	resp := j.response()
+	p := callbackPayload{
+		Event:      "job." + j.Status,
+		JobID:      j.ID,
+		Status:     j.Status,
+		StatusURL:  j.BaseURL + "/jobs/" + j.ID,
+		Total:      resp.Total,
+		Completed:  resp.Completed,
+		Failed:     resp.Failed,
+		FinishedAt: j.Finished,
+	}
+	if resp.Completed > 0 {
+		p.ResultURL = p.StatusURL + "/result"
+	}
+	for _, item := range j.Items {
+		if item.Error != "" {
+			p.Error = item.Error
+			break
+		}
+	}
+	return p
+}
+
+// deliverCallback posts the notification for finished job id until the
+// receiver answers 2xx, backing off exponentially between attempts. When
+// every attempt fails the notification goes to the dead-letter log.
+func (m *jobManager) deliverCallback(id, target string, payload callbackPayload) {
+	body, _ := json.Marshal(payload)
+	delay := callbackBaseDelay
+	var lastErr error
+	for attempt := 1; attempt <= callbackAttempts; attempt++ {
+		if lastErr = postCallback(target, body); lastErr == nil {
+			appLog.Infof("job %s callback delivered (attempt %d)", id, attempt)
+			m.setCallbackState(id, callbackDelivered)
+			return
+		}
+		if attempt == callbackAttempts {
+			break
+		}
+		appLog.Warnf("job %s callback attempt %d failed: %v", id, attempt, lastErr)
+		// Up to a fifth more, so retries from many jobs spread out
+		time.Sleep(delay + rand.N(delay/5+1))
+		delay = min(delay*2, callbackMaxDelay)
+	}
+	appLog.Errorf("job %s callback to %s failed after %d attempts: %v", id, target, callbackAttempts, lastErr)
+	m.deadLetter(target, payload, lastErr)
+	m.setCallbackState(id, callbackDead)
+}
+
+func postCallback(target string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Voxlattice")
+	req.Header.Set("X-Voxlattice-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Voxlattice-Signature", "sha256="+signCallback(callbackSecret(), timestamp, body))
+	resp, err := callbackClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return nil
+}
+
+// deadLetter appends an undeliverable notification to
+// <config>/jobs/dead-letters.jsonl so it can be replayed by hand.
+func (m *jobManager) deadLetter(target string, payload callbackPayload, cause error) {
+	line, _ := json.Marshal(deadLetter{
+		Time:        time.Now().UTC(),
+		CallbackURL: target,
+		Attempts:    callbackAttempts,
+		Error:       cause.Error(),
+		Payload:     payload,
+	})
+	deadLetterMu.Lock()
+	defer deadLetterMu.Unlock()
+	f, err := os.OpenFile(filepath.Join(m.dir, deadLetterFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		appLog.Errorf("dead-letter log open failed: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		appLog.Errorf("dead-letter log write failed: %v", err)
+	}
+}
+
+func (m *jobManager) setCallbackState(id, state string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if j, ok := m.jobs[id]; ok {
+		j.Callback = state
+		m.saveLocked(j)
+	}
+}
+
+// notifyLocked starts delivering the callback of job j if it has one.
+// Only success and failure are reported; a caller who cancels knows.
+func (m *jobManager) notifyLocked(j *jobRecord) {
+	if j.CallbackURL == "" || (j.Status != jobSucceeded && j.Status != jobFailed) {
+		return
+	}
+	j.Callback = callbackPending
+	go m.deliverCallback(j.ID, j.CallbackURL, callbackPayloadFor(j))
+}
@@ -0,0 +1,157 @@
+package voxlattice
+
+import (
+	"crypto/hmac"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSignCallback(t *testing.T) {
+	tests := []struct {
+		secret    string
+		timestamp int64
+		body      string
+		want      string
+	}{
+		{"secret", 1700000000, `{"id":"j1"}`, "1c67234337a0ba7e1f64f75cf610bec867d07e75e853a67b8469845f6eeef78e"},
+		{"k", 0, "", "6b4a4b8b3c40f1e8f53a3d36682e5f99f7ad2ac1df1c93dfe336f329167641e7"},
+		{"密钥", 1, "body", "34154b08f59bc3255e43910d599ccb93a498c34d9cd9a0ac76b09711922521f3"},
+	}
+	for _, tt := range tests {
+		if got := signCallback(tt.secret, tt.timestamp, []byte(tt.body)); got != tt.want {
+			t.Errorf("signCallback(%q, %d, %q) = %s, want %s", tt.secret, tt.timestamp, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestPostCallback(t *testing.T) {
+	t.Setenv("AUDIOMESH_CALLBACK_SECRET", "s3cret")
+	t.Setenv("AUDIOMESH_CALLBACK_ALLOW", "127.0.0.0/8,::1/128")
+	body := []byte(`{"event":"job.succeeded"}`)
+
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{"accepted", http.StatusNoContent, false},
+		{"refused", http.StatusInternalServerError, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, _ := io.ReadAll(r.Body)
+				ts, err := strconv.ParseInt(r.Header.Get("X-Voxlattice-Timestamp"), 10, 64)
+				if err != nil {
+					t.Errorf("bad timestamp header: %v", err)
+				}
+				sig := strings.TrimPrefix(r.Header.Get("X-Voxlattice-Signature"), "sha256=")
+				if want := signCallback("s3cret", ts, body); !hmac.Equal([]byte(sig), []byte(want)) {
+					t.Errorf("signature %s, want %s", sig, want)
+				}
+				if string(got) != string(body) {
+					t.Errorf("body %s, want %s", got, body)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			err := postCallback(srv.URL, body)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("postCallback = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPostCallbackRefusesBlockedAddress(t *testing.T) {
+	t.Setenv("AUDIOMESH_CALLBACK_SECRET", "s3cret")
+	called := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer srv.Close()
+
+	if err := postCallback(srv.URL, []byte("{}")); err == nil {
+		t.Error("postCallback to a loopback address succeeded")
+	}
+	if called {
+		t.Error("the loopback receiver was reached")
+	}
+}
+
+func TestCallbackIPAllowed(t *testing.T) {
+	tests := []struct {
+		ip    string
+		allow string
+		want  bool
+	}{
+		{"93.184.216.34", "", true},
+		{"2606:2800:220:1::1", "", true},
+		{"127.0.0.1", "", false},
+		{"::1", "", false},
+		{"10.1.2.3", "", false},
+		{"172.16.0.1", "", false},
+		{"192.168.1.1", "", false},
+		{"169.254.169.254", "", false},
+		{"fe80::1", "", false},
+		{"fd00::1", "", false},
+		{"0.0.0.0", "", false},
+		{"100.64.0.1", "", false},
+		{"224.0.0.1", "", false},
+		{"64:ff9b::7f00:1", "", false},
+		{"::ffff:127.0.0.1", "", false},
+		{"10.1.2.3", "10.1.0.0/16", true},
+		{"10.2.0.1", "10.1.0.0/16", false},
+		{"127.0.0.1", "bogus, 127.0.0.0/8", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip+"/"+tt.allow, func(t *testing.T) {
+			t.Setenv("AUDIOMESH_CALLBACK_ALLOW", tt.allow)
+			if got := callbackIPAllowed(net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("callbackIPAllowed(%s) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateCallbackURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		url       string
+		secret    string
+		publicURL string
+		wantErr   string
+	}{
+		{"ok", "https://hooks.example.com/tts", "s", "https://tts.example.com", ""},
+		{"http", "http://hooks.example.com/tts", "s", "https://tts.example.com", ""},
+		{"scheme", "ftp://hooks.example.com/tts", "s", "https://tts.example.com", "invalid callback_url"},
+		{"relative", "/tts", "s", "https://tts.example.com", "invalid callback_url"},
+		{"loopback", "http://127.0.0.1:8080/", "s", "https://tts.example.com", "not allowed"},
+		{"ipv6 loopback", "http://[::1]/", "s", "https://tts.example.com", "not allowed"},
+		{"metadata", "http://169.254.169.254/latest", "s", "https://tts.example.com", "not allowed"},
+		{"localhost", "http://LocalHost./", "s", "https://tts.example.com", "not allowed"},
+		{"sub localhost", "http://app.localhost/", "s", "https://tts.example.com", "not allowed"},
+		{"no secret", "https://hooks.example.com/tts", "", "https://tts.example.com", "AUDIOMESH_CALLBACK_SECRET"},
+		{"no public url", "https://hooks.example.com/tts", "s", "", "AUDIOMESH_PUBLIC_URL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AUDIOMESH_CALLBACK_SECRET", tt.secret)
+			t.Setenv("AUDIOMESH_PUBLIC_URL", tt.publicURL)
+			t.Setenv("AUDIOMESH_CALLBACK_ALLOW", "")
+			err := validateCallbackURL(tt.url)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("validateCallbackURL(%q) = %v, want nil", tt.url, err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("validateCallbackURL(%q) = %v, want an error containing %q", tt.url, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,470 @@
+package voxlattice
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Sentence terminators that end a sentence wherever they appear.
+const cjkSentenceEnds = "。！？…"
+
+// Sentence terminators that only count when followed by whitespace or the
+// end of the text, so "3.14" or "v1.2" are not split.
+const latinSentenceEnds = ".!?"
+
+// Closing quotes and brackets that stay attached to the sentence before them.
+const sentenceClosers = "\"')]}”’」』）】》"
+
+// Soft break points used when a single sentence exceeds the chunk size.
+const clauseBreaks = ",;:，、；："
+
+// splitText breaks text into chunks of at most maxLen bytes, cutting at
+// paragraph boundaries first, then sentences, then clauses, and only as a
+// last resort in the middle of a clause.
+func splitText(text string, maxLen int) []string {
+	if len(text) <= maxLen {
+		return []string{text}
+	}
+
+	var chunks []string
+	var cur strings.Builder
+	flush := func() {
+		if s := strings.TrimSpace(cur.String()); s != "" {
+			chunks = append(chunks, s)
+		}
+		cur.Reset()
+	}
+	add := func(piece, sep string) {
+		if cur.Len() > 0 && cur.Len()+len(sep)+len(piece) > maxLen {
+			flush()
+		}
+		if cur.Len() > 0 {
+			cur.WriteString(sep)
+		}
+		cur.WriteString(piece)
+	}
+
+	for _, para := range strings.Split(text, "\n\n") {
+		para = strings.TrimSpace(para)
+		if para == "" {
+			continue
+		}
+		// Start a new chunk at a paragraph boundary rather than packing the
+		// paragraph's first sentences onto the previous one.
+		if cur.Len() > 0 && cur.Len()+2+len(para) > maxLen {
+			flush()
+		}
+		sep := "\n\n"
+		for _, sentence := range splitSentences(para) {
+			for _, piece := range splitLong(sentence, maxLen) {
+				add(piece, sep)
+				sep = pieceSeparator(piece)
+			}
+		}
+	}
+	flush()
+	return chunks
+}
+
+// pieceSeparator returns the separator to put after piece: a space after
+// Latin text, nothing after CJK text which is written without spaces.
+func pieceSeparator(piece string) string {
+	trimmed := strings.TrimRight(piece, sentenceClosers)
+	if trimmed == "" {
+		return " "
+	}
+	r, _ := utf8.DecodeLastRuneInString(trimmed)
+	if isCJKRune(r) {
+		return ""
+	}
+	return " "
+}
+
+func isCJKRune(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) ||
+		(r >= 0x3000 && r <= 0x303F) || (r >= 0xFF00 && r <= 0xFFEF)
+}
+
+// splitSentences cuts a paragraph after each sentence terminator, keeping the
+// terminator and any closing quotes with the sentence.
+func splitSentences(para string) []string {
+	var out []string
+	start := 0
+	for i := 0; i < len(para); {
+		r, size := utf8.DecodeRuneInString(para[i:])
+		i += size
+		cjk := strings.ContainsRune(cjkSentenceEnds, r)
+		if !cjk && !strings.ContainsRune(latinSentenceEnds, r) {
+			continue
+		}
+		// Swallow repeated terminators ("?!", "……") and closing quotes.
+		for i < len(para) {
+			next, n := utf8.DecodeRuneInString(para[i:])
+			if !strings.ContainsRune(cjkSentenceEnds+latinSentenceEnds+sentenceClosers, next) {
+				break
+			}
+			i += n
+		}
+		if !cjk && i < len(para) {
+			next, _ := utf8.DecodeRuneInString(para[i:])
+			if !unicode.IsSpace(next) {
+				continue
+			}
+		}
+		if s := strings.TrimSpace(para[start:i]); s != "" {
+			out = append(out, s)
+		}
+		start = i
+	}
+	if s := strings.TrimSpace(para[start:]); s != "" {
+		out = append(out, s)
+	}
+	return out
+}
+
+// completeSentences splits streamed text into the sentences known to be
+// finished and the rest that may still grow. A terminator at the very end of
+// buf does not count yet: a closing quote or, for Latin text, a non-space
+// (as in "3.14") may still follow. Overlong rests are cut with splitLong.
+func completeSentences(buf string) ([]string, string) {
+	end := 0
+	for i := 0; i < len(buf); {
+		r, size := utf8.DecodeRuneInString(buf[i:])
+		i += size
+		if r == '\n' {
+			end = i
+			continue
+		}
+		cjk := strings.ContainsRune(cjkSentenceEnds, r)
+		if !cjk && !strings.ContainsRune(latinSentenceEnds, r) {
+			continue
+		}
+		for i < len(buf) {
+			next, n := utf8.DecodeRuneInString(buf[i:])
+			if !strings.ContainsRune(cjkSentenceEnds+latinSentenceEnds+sentenceClosers, next) {
+				break
+			}
+			i += n
+		}
+		if i == len(buf) {
+			break
+		}
+		if !cjk {
+			next, _ := utf8.DecodeRuneInString(buf[i:])
+			if !unicode.IsSpace(next) {
+				continue
+			}
+		}
+		end = i
+	}
+
+	var sentences []string
+	for _, para := range strings.Split(buf[:end], "\n") {
+		for _, sentence := range splitSentences(para) {
+			sentences = append(sentences, splitLong(sentence, maxChunkLen)...)
+		}
+	}
+	rest := buf[end:]
+	if len(rest) > maxChunkLen {
+		pieces := splitLong(rest, maxChunkLen)
+		last := pieces[len(pieces)-1]
+		sentences = append(sentences, pieces[:len(pieces)-1]...)
+		rest = rest[strings.LastIndex(rest, last):]
+	}
+	return sentences, rest
+}
+
+// splitLong cuts an oversized sentence at the last clause break or space
+// before maxLen, falling back to a rune boundary.
+func splitLong(s string, maxLen int) []string {
+	var out []string
+	for len(s) > maxLen {
+		cut := -1
+		for i, r := range s {
+			if i >= maxLen {
+				break
+			}
+			if strings.ContainsRune(clauseBreaks, r) {
+				cut = i + utf8.RuneLen(r)
+			}
+		}
+		if cut <= 0 {
+			cut = strings.LastIndexFunc(s[:maxLen], unicode.IsSpace)
+		}
+		if cut <= 0 {
+			cut = maxLen
+			for cut > 0 && !utf8.RuneStart(s[cut]) {
+				cut--
+			}
+		}
+		if piece := strings.TrimSpace(s[:cut]); piece != "" {
+			out = append(out, piece)
+		}
+		s = strings.TrimSpace(s[cut:])
+	}
+	if s != "" {
+		out = append(out, s)
+	}
+	return out
+}
+
+// synthesizeChunks synthesizes each chunk separately (one Live session per
+// chunk with the Gemini backend) and joins the audio with the stitcher, so
+// callers see one continuous PCM stream.
+func synthesizeChunks(ctx context.Context, req synthRequest, chunks []string, pauseMs int, emit func([]byte) error) error {
+	parts := make([]speechPart, len(chunks))
+	for i, chunk := range chunks {
+		parts[i] = speechPart{text: chunk, pauseMs: pauseMs}
+	}
+	return synthesizeParts(ctx, req, parts, emit)
+}
+
+// speechPart is one backend call of a synthesis plan: a chunk of text, how
+// to deliver it and the silence that precedes it.
+type speechPart struct {
+	text    string
+	style   string // delivery instruction, e.g. "slowly"
+	lang    string // overrides the request language
+	pauseMs int    // silence before the part; 0 crossfades into it
+}
+
+// speechPlan is everything one request asks of the backend: the parts in
+// order plus any silence before and after them.
+type speechPlan struct {
+	parts      []speechPart
+	leadingMs  int
+	trailingMs int
+}
+
+// textPlan splits plain text into chunks separated by pauseMs.
+func textPlan(text string, pauseMs int) speechPlan {
+	var plan speechPlan
+	for _, chunk := range splitText(text, maxChunkLen) {
+		plan.parts = append(plan.parts, speechPart{text: chunk, pauseMs: pauseMs})
+	}
+	return plan
+}
+
+// synthesizePlan synthesizes the parts of plan and adds its leading and
+// trailing silence.
+func synthesizePlan(ctx context.Context, req synthRequest, plan speechPlan, emit func([]byte) error) error {
+	if plan.leadingMs > 0 {
+		if err := emit(silencePCM(plan.leadingMs)); err != nil {
+			return err
+		}
+	}
+	if err := synthesizeParts(ctx, req, plan.parts, emit); err != nil {
+		return err
+	}
+	if plan.trailingMs > 0 {
+		return emit(silencePCM(plan.trailingMs))
+	}
+	return nil
+}
+
+func silencePCM(ms int) []byte {
+	return make([]byte, ms*sampleRateHz/1000*channels*bitsPerSample/8)
+}
+
+// synthesizeParts synthesizes each part separately and stitches the audio
+// like synthesizeChunks, with the pause before each part taken from the part.
+func synthesizeParts(ctx context.Context, req synthRequest, parts []speechPart, emit func([]byte) error) error {
+	st := newPCMStitcher(emit, chunkFadeMs, 0)
+	for i, p := range parts {
+		part := req
+		part.Text = p.text
+		if p.style != "" {
+			part.Style = p.style
+		}
+		if p.lang != "" {
+			part.Lang = p.lang
+		}
+		st.setPause(p.pauseMs)
+		if err := st.startChunk(); err != nil {
+			return err
+		}
+		heard, err := speakChecked(ctx, part, st.write)
+		if err != nil {
+			if len(parts) > 1 {
+				return fmt.Errorf("chunk %d/%d: %w", i+1, len(parts), err)
+			}
+			return err
+		}
+		// Without a transcription the part's own text stands in for it;
+		// either way the part ends on a word boundary.
+		if req.onTranscript != nil {
+			if heard {
+				req.onTranscript(" ")
+			} else {
+				req.onTranscript(p.text + " ")
+			}
+		}
+		logFrom(ctx).Debugf("tts chunk %d/%d done: %d bytes of text", i+1, len(parts), len(p.text))
+	}
+	return st.finish()
+}
+
+// pcmStitcher joins the PCM of consecutive chunks. Without a pause the end of
+// one chunk is crossfaded into the start of the next; with a pause the tail
+// fades out, silence is inserted and the next chunk fades in. The last
+// fadeMs of each chunk are held back until the next chunk (or the end) is
+// known, everything else is passed through immediately.
+type pcmStitcher struct {
+	emit        func([]byte) error
+	fadeBytes   int
+	pauseBytes  int
+	bytesPerMs  int
+	chunks      int
+	tail        []byte // held-back end of the current chunk
+	prevTail    []byte // end of the previous chunk awaiting a crossfade
+	head        []byte // start of the current chunk collected for the crossfade
+	fadeInBytes int    // bytes of the current chunk still to fade in
+}
+
+func newPCMStitcher(emit func([]byte) error, fadeMs, pauseMs int) *pcmStitcher {
+	bytesPerMs := sampleRateHz / 1000 * channels * bitsPerSample / 8
+	return &pcmStitcher{
+		emit:       emit,
+		fadeBytes:  fadeMs * bytesPerMs,
+		pauseBytes: pauseMs * bytesPerMs,
+		bytesPerMs: bytesPerMs,
+	}
+}
+
+// setPause changes the silence inserted before the next chunk.
+func (s *pcmStitcher) setPause(pauseMs int) {
+	s.pauseBytes = pauseMs * s.bytesPerMs
+}
+
+func (s *pcmStitcher) startChunk() error {
+	s.chunks++
+	if s.chunks == 1 {
+		return nil
+	}
+	if err := s.resolveCrossfade(); err != nil {
+		return err
+	}
+	prev := s.tail
+	s.tail = nil
+	if s.pauseBytes == 0 {
+		s.prevTail = prev
+		return nil
+	}
+	out := append(applyFade(prev, false), make([]byte, s.pauseBytes)...)
+	s.fadeInBytes = s.fadeBytes
+	return s.emit(out)
+}
+
+func (s *pcmStitcher) write(pcm []byte) error {
+	if s.prevTail != nil {
+		s.head = append(s.head, pcm...)
+		if len(s.head) < len(s.prevTail) {
+			return nil
+		}
+		pcm = s.head
+		s.head = nil
+		if err := s.resolveCrossfadeWith(&pcm); err != nil {
+			return err
+		}
+	}
+	if s.fadeInBytes > 0 {
+		n := min(s.fadeInBytes, len(pcm)) &^ 1
+		offset := s.fadeBytes - s.fadeInBytes
+		scaleRamp(pcm[:n], offset, s.fadeBytes, true)
+		s.fadeInBytes -= n
+	}
+
+	buf := append(s.tail, pcm...)
+	keep := min(s.fadeBytes, len(buf)) &^ 1
+	out := buf[:len(buf)-keep]
+	s.tail = append([]byte(nil), buf[len(buf)-keep:]...)
+	if len(out) == 0 {
+		return nil
+	}
+	return s.emit(out)
+}
+
+// resolveCrossfade mixes any pending previous tail with whatever head has
+// been collected, for chunks shorter than the fade.
+func (s *pcmStitcher) resolveCrossfade() error {
+	if s.prevTail == nil {
+		return nil
+	}
+	head := s.head
+	s.head = nil
+	if err := s.resolveCrossfadeWith(&head); err != nil {
+		return err
+	}
+	s.tail = append(s.tail, head...)
+	return nil
+}
+
+// resolveCrossfadeWith overlaps the previous tail with the start of *pcm,
+// emits the mixed audio and leaves the unmixed rest in *pcm.
+func (s *pcmStitcher) resolveCrossfadeWith(pcm *[]byte) error {
+	prev := s.prevTail
+	s.prevTail = nil
+	n := min(len(prev), len(*pcm)) &^ 1
+	out := append([]byte(nil), prev[:len(prev)-n]...)
+	out = append(out, mixCrossfade(prev[len(prev)-n:], (*pcm)[:n])...)
+	*pcm = (*pcm)[n:]
+	if len(out) == 0 {
+		return nil
+	}
+	return s.emit(out)
+}
+
+func (s *pcmStitcher) finish() error {
+	if err := s.resolveCrossfade(); err != nil {
+		return err
+	}
+	if len(s.tail) == 0 {
+		return nil
+	}
+	tail := s.tail
+	s.tail = nil
+	return s.emit(tail)
+}
+
+// applyFade returns a copy of pcm with a linear fade in or out across it.
+func applyFade(pcm []byte, in bool) []byte {
+	out := append([]byte(nil), pcm...)
+	n := len(out) &^ 1
+	scaleRamp(out[:n], 0, n, in)
+	return out
+}
+
+// scaleRamp scales pcm in place as the slice starting offset bytes into a
+// linear ramp of total bytes, rising when in is set and falling otherwise.
+func scaleRamp(pcm []byte, offset, total int, in bool) {
+	steps := total / 2
+	if steps == 0 {
+		return
+	}
+	for i := 0; i+1 < len(pcm); i += 2 {
+		pos := float64((offset+i)/2) / float64(steps)
+		gain := pos
+		if !in {
+			gain = 1 - pos
+		}
+		v := int16(binary.LittleEndian.Uint16(pcm[i:]))
+		binary.LittleEndian.PutUint16(pcm[i:], uint16(clampSample(float64(v)*gain)))
+	}
+}
+
+// mixCrossfade overlaps two equally long PCM slices, fading a out and b in.
+func mixCrossfade(a, b []byte) []byte {
+	out := make([]byte, len(a))
+	steps := len(a) / 2
+	for i := 0; i+1 < len(a); i += 2 {
+		gain := float64(i/2) / float64(steps)
+		va := float64(int16(binary.LittleEndian.Uint16(a[i:])))
+		vb := float64(int16(binary.LittleEndian.Uint16(b[i:])))
+		binary.LittleEndian.PutUint16(out[i:], uint16(clampSample(va*(1-gain)+vb*gain)))
+	}
+	return out
+}
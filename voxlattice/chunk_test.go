@@ -0,0 +1,156 @@
+package voxlattice
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitText(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		maxLen int
+		want   []string
+	}{
+		{
+			name:   "fits",
+			text:   "Hello there. How are you?",
+			maxLen: 100,
+			want:   []string{"Hello there. How are you?"},
+		},
+		{
+			name:   "sentences",
+			text:   "One two three. Four five six. Seven eight nine.",
+			maxLen: 30,
+			want:   []string{"One two three. Four five six.", "Seven eight nine."},
+		},
+		{
+			name:   "decimal point",
+			text:   "Pi is 3.14 or so. The end is near.",
+			maxLen: 20,
+			want:   []string{"Pi is 3.14 or so.", "The end is near."},
+		},
+		{
+			name:   "closing quote",
+			text:   `He said "stop." Then he left.`,
+			maxLen: 20,
+			want:   []string{`He said "stop."`, "Then he left."},
+		},
+		{
+			name:   "paragraphs",
+			text:   "First one.\n\nSecond one.",
+			maxLen: 15,
+			want:   []string{"First one.", "Second one."},
+		},
+		{
+			name:   "paragraph kept whole",
+			text:   "Short.\n\nA longer paragraph. With two sentences.",
+			maxLen: 45,
+			want:   []string{"Short.", "A longer paragraph. With two sentences."},
+		},
+		{
+			name:   "cjk",
+			text:   "你好。今天天气很好！我们出去走走吧？",
+			maxLen: 40,
+			want:   []string{"你好。今天天气很好！", "我们出去走走吧？"},
+		},
+		{
+			name:   "clause",
+			text:   "alpha beta gamma, delta epsilon zeta",
+			maxLen: 20,
+			want:   []string{"alpha beta gamma,", "delta epsilon zeta"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitText(tt.text, tt.maxLen)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitText(%q, %d) = %q, want %q", tt.text, tt.maxLen, got, tt.want)
+			}
+			for _, c := range got {
+				if len(c) > tt.maxLen {
+					t.Errorf("chunk %q is %d bytes, over %d", c, len(c), tt.maxLen)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitLong(t *testing.T) {
+	tests := []struct {
+		name   string
+		s      string
+		maxLen int
+		want   []string
+	}{
+		{"short", "abc", 10, []string{"abc"}},
+		{"clause break", "aaaa, bbbb; cccc", 8, []string{"aaaa,", "bbbb;", "cccc"}},
+		{"space", "aaaa bbbb cccc", 10, []string{"aaaa bbbb", "cccc"}},
+		{"hard cut", "abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"rune boundary", "你好世界", 7, []string{"你好", "世界"}},
+		{"cjk clause", "一二三，四五六", 12, []string{"一二三，", "四五六"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitLong(tt.s, tt.maxLen)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitLong(%q, %d) = %q, want %q", tt.s, tt.maxLen, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitTextKeepsAllText(t *testing.T) {
+	text := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 200)
+	chunks := splitText(text, maxChunkLen)
+	if len(chunks) < 2 {
+		t.Fatalf("got %d chunks, want several", len(chunks))
+	}
+	if got, want := strings.Join(chunks, " "), strings.TrimSpace(text); got != want {
+		t.Errorf("rejoined chunks differ from the input")
+	}
+}
+
+func TestCompleteSentences(t *testing.T) {
+	tests := []struct {
+		name      string
+		buf       string
+		sentences []string
+		rest      string
+	}{
+		{"empty", "", nil, ""},
+		{"unfinished", "Hello the", nil, "Hello the"},
+		{"terminator at end", "Hello there.", nil, "Hello there."},
+		{"finished", "Hello there. How", []string{"Hello there."}, " How"},
+		{"decimal", "It costs 3.", nil, "It costs 3."},
+		{"decimal continued", "It costs 3.5 now. Ok", []string{"It costs 3.5 now."}, " Ok"},
+		{"quote may follow", `He said "hi.`, nil, `He said "hi.`},
+		{"quote followed", `He said "hi." Then`, []string{`He said "hi."`}, " Then"},
+		{"cjk", "你好。今天", []string{"你好。"}, "今天"},
+		{"newline", "no terminator\nnext", []string{"no terminator"}, "next"},
+		{"two", "One. Two! Three", []string{"One.", "Two!"}, " Three"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sentences, rest := completeSentences(tt.buf)
+			if !reflect.DeepEqual(sentences, tt.sentences) || rest != tt.rest {
+				t.Errorf("completeSentences(%q) = %q, %q, want %q, %q", tt.buf, sentences, rest, tt.sentences, tt.rest)
+			}
+		})
+	}
+}
+
+func TestCompleteSentencesCutsLongRest(t *testing.T) {
+	buf := strings.Repeat("word ", maxChunkLen/5+50)
+	sentences, rest := completeSentences(buf)
+	if len(sentences) == 0 {
+		t.Fatalf("got no sentences from a %d byte rest", len(buf))
+	}
+	if len(rest) > maxChunkLen {
+		t.Errorf("rest is %d bytes, over %d", len(rest), maxChunkLen)
+	}
+	if got := strings.Join(append(sentences, strings.TrimSpace(rest)), " "); got != strings.TrimSpace(buf) {
+		t.Errorf("sentences and rest do not add up to the input")
+	}
+}
@@ -0,0 +1,225 @@
+package voxlattice
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Cloud TTS audioEncoding values mapped to output format names. LINEAR16,
+// MULAW and ALAW come wrapped in a WAV header, PCM does not.
+var cloudEncodings = map[string]string{
+	"LINEAR16": "wav",
+	"MP3":      "mp3",
+	"OGG_OPUS": "opus",
+	"MULAW":    "mulaw_wav",
+	"ALAW":     "alaw_wav",
+	"PCM":      "pcm_s16le",
+}
+
+// Languages the Gemini voices speak; every voice covers all of them.
+var geminiLanguageCodes = []string{
+	"ar-EG", "bn-BD", "de-DE", "en-IN", "en-US", "es-US", "fr-FR", "hi-IN",
+	"id-ID", "it-IT", "ja-JP", "ko-KR", "mr-IN", "nl-NL", "pl-PL", "pt-BR",
+	"ro-RO", "ru-RU", "ta-IN", "te-IN", "th-TH", "tr-TR", "uk-UA", "vi-VN",
+}
+
+var cloudStatusNames = map[int]string{
+	http.StatusBadRequest:          "INVALID_ARGUMENT",
+	http.StatusUnauthorized:        "UNAUTHENTICATED",
+	http.StatusForbidden:           "PERMISSION_DENIED",
+	http.StatusNotFound:            "NOT_FOUND",
+	http.StatusMethodNotAllowed:    "UNIMPLEMENTED",
+	http.StatusTooManyRequests:     "RESOURCE_EXHAUSTED",
+	http.StatusInternalServerError: "INTERNAL",
+	http.StatusBadGateway:          "UNAVAILABLE",
+}
+
+func writeCloudError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(cloudErrorResp{Error: cloudError{
+		Code:    status,
+		Message: message,
+		Status:  cloudStatusNames[status],
+	}})
+}
+
+// cloudAPIKey also accepts the ways Google client libraries send keys.
+func cloudAPIKey(r *http.Request) (callerAuth, error) {
+	return resolveAPIKey(r, r.Header.Get("X-Goog-Api-Key"), r.URL.Query().Get("key"))
+}
+
+// voiceGender reads the gender out of a voice description such as
+// "Kore - Female voice".
+func voiceGender(description string) string {
+	d := strings.ToLower(description)
+	switch {
+	case strings.Contains(d, "female"):
+		return "FEMALE"
+	case strings.Contains(d, "male"):
+		return "MALE"
+	case strings.Contains(d, "neutral"):
+		return "NEUTRAL"
+	}
+	return "SSML_VOICE_GENDER_UNSPECIFIED"
+}
+
+// cloudSynthesizeHandler serves a Cloud Text-to-Speech compatible
+// POST /v1/text:synthesize.
+func cloudSynthesizeHandler(w http.ResponseWriter, r *http.Request) {
+	// Allow CORS for web access
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Goog-Api-Key")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeCloudError(w, http.StatusMethodNotAllowed, "POST only")
+		return
+	}
+
+	var in cloudSynthesizeReq
+	body, err := io.ReadAll(r.Body)
+	if err != nil || json.Unmarshal(body, &in) != nil {
+		writeCloudError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	if in.Input.Text != "" && in.Input.SSML != "" {
+		writeCloudError(w, http.StatusBadRequest, "input.text and input.ssml are mutually exclusive")
+		return
+	}
+	var text string
+	var doc ssmlDoc
+	if in.Input.SSML != "" {
+		if doc, err = parseValidSSML(in.Input.SSML); err != nil {
+			writeCloudError(w, http.StatusBadRequest, "input.ssml: "+err.Error())
+			return
+		}
+	} else if text, err = normalizeText(in.Input.Text); err != nil {
+		writeCloudError(w, http.StatusBadRequest, "input.text: "+err.Error())
+		return
+	}
+
+	voice := strings.ToLower(strings.TrimSpace(in.Voice.Name))
+	if voice != "" {
+		if _, exists := currentVoices()[voice]; !exists {
+			writeCloudError(w, http.StatusBadRequest, fmt.Sprintf("unsupported voice.name: %s, supported voices: %v", in.Voice.Name, getSupportedVoiceNames()))
+			return
+		}
+	}
+
+	encoding := strings.ToUpper(strings.TrimSpace(in.AudioConfig.AudioEncoding))
+	formatName, ok := cloudEncodings[encoding]
+	if !ok {
+		writeCloudError(w, http.StatusBadRequest, fmt.Sprintf("unsupported audioConfig.audioEncoding: %q, supported: %s", in.AudioConfig.AudioEncoding, supportedNames(cloudEncodings, "LINEAR16", "MP3", "OGG_OPUS", "MULAW", "ALAW", "PCM")))
+		return
+	}
+
+	speed := in.AudioConfig.SpeakingRate
+	if speed == 0 {
+		speed = 1
+	}
+	if speed < minSpeed || speed > maxSpeed {
+		writeCloudError(w, http.StatusBadRequest, fmt.Sprintf("audioConfig.speakingRate must be between %g and %g", minSpeed, maxSpeed))
+		return
+	}
+
+	req := ttsReq{
+		Text:       text,
+		SSML:       in.Input.SSML,
+		Voice:      voice,
+		Lang:       in.Voice.LanguageCode,
+		Format:     formatName,
+		SampleRate: in.AudioConfig.SampleRateHertz,
+		Speed:      speed,
+	}
+	format, opts, err := resolveOutput(r, req)
+	if err != nil {
+		writeCloudError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	auth, err := cloudAPIKey(r)
+	if err == nil {
+		err = auth.checkAccess(getModelName(), voice)
+	}
+	if err != nil {
+		writeCloudError(w, authErrorStatus(err), err.Error())
+		return
+	}
+	adm, err := admitRequest(r, auth, text+doc.text())
+	if err != nil {
+		setRetryAfter(w, err)
+		writeCloudError(w, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	defer adm.done()
+	noteSpeech(r, voice, text+doc.text())
+
+	sreq := synthRequest{Text: text, Voice: voice, Lang: req.Lang, Model: getModelName(), APIKey: auth.apiKey}
+	audio, err := renderSpeech(w, r, adm, req, doc, sreq, format, opts, defaultChunkPauseMs)
+	var limErr *limitError
+	if errors.As(err, &limErr) {
+		setRetryAfter(w, err)
+		writeCloudError(w, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	if err != nil {
+		adm.refund()
+		if r.Context().Err() != nil {
+			reqLog(r).Debugf("tts client went away: %v", err)
+			return
+		}
+		countRequestError(r, err)
+		writeCloudError(w, synthErrorStatus(err), err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cloudSynthesizeResp{AudioContent: audio})
+}
+
+// cloudVoicesHandler serves a Cloud Text-to-Speech compatible GET /v1/voices
+// backed by the supported voices, filtered by the optional languageCode.
+func cloudVoicesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method != http.MethodGet {
+		writeCloudError(w, http.StatusMethodNotAllowed, "GET only")
+		return
+	}
+
+	langs := geminiLanguageCodes
+	if want := strings.TrimSpace(r.URL.Query().Get("languageCode")); want != "" {
+		langs = nil
+		for _, code := range geminiLanguageCodes {
+			// "en" matches every English variant, "en-US" only itself
+			if strings.EqualFold(code, want) || strings.HasPrefix(strings.ToLower(code), strings.ToLower(want)+"-") {
+				langs = append(langs, code)
+			}
+		}
+	}
+
+	resp := cloudVoicesResp{Voices: []cloudVoice{}}
+	if len(langs) > 0 {
+		voices := currentVoices()
+		for _, name := range sortedVoiceNames(voices) {
+			resp.Voices = append(resp.Voices, cloudVoice{
+				LanguageCodes:          langs,
+				Name:                   name,
+				SSMLGender:             voiceGender(voices[name]),
+				NaturalSampleRateHertz: sampleRateHz,
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
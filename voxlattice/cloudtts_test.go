@@ -0,0 +1,109 @@
+package voxlattice
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestCloudSynthesizeHandler(t *testing.T) {
+	useFakeBackend(t)
+	useVoices(t, "kore")
+
+	tests := []struct {
+		name  string
+		body  string
+		size  int
+		magic string
+	}{
+		{"linear16", `{"input":{"text":"hello"},"voice":{"name":"kore"},"audioConfig":{"audioEncoding":"LINEAR16"}}`, 44 + fakePCMBytes("hello"), "RIFF"},
+		{"pcm", `{"input":{"text":"hello"},"audioConfig":{"audioEncoding":"PCM"}}`, fakePCMBytes("hello"), ""},
+		{"mulaw", `{"input":{"text":"hello"},"audioConfig":{"audioEncoding":"MULAW","sampleRateHertz":8000}}`, 0, "RIFF"},
+		{"mp3", `{"input":{"text":"hello"},"audioConfig":{"audioEncoding":"mp3"}}`, 0, ""},
+		{"ssml", `{"input":{"ssml":"<speak>hello<break time=\"500ms\"/>world</speak>"},"audioConfig":{"audioEncoding":"PCM"}}`, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(cloudSynthesizeHandler, http.MethodPost, "/v1/text:synthesize", tt.body)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+			var resp cloudSynthesizeResp
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if tt.size != 0 && len(resp.AudioContent) != tt.size {
+				t.Errorf("audio is %d bytes, want %d", len(resp.AudioContent), tt.size)
+			}
+			if len(resp.AudioContent) == 0 || !bytes.HasPrefix(resp.AudioContent, []byte(tt.magic)) {
+				t.Errorf("audio does not start with %q", tt.magic)
+			}
+		})
+	}
+}
+
+func TestCloudSynthesizeHandlerErrors(t *testing.T) {
+	useFakeBackend(t)
+	useVoices(t, "kore")
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+	}{
+		{"method", http.MethodGet, "", http.StatusMethodNotAllowed},
+		{"bad json", http.MethodPost, `{"input":`, http.StatusBadRequest},
+		{"text and ssml", http.MethodPost, `{"input":{"text":"hi","ssml":"<speak>hi</speak>"},"audioConfig":{"audioEncoding":"MP3"}}`, http.StatusBadRequest},
+		{"bad ssml", http.MethodPost, `{"input":{"ssml":"<speak>hi"},"audioConfig":{"audioEncoding":"MP3"}}`, http.StatusBadRequest},
+		{"unknown voice", http.MethodPost, `{"input":{"text":"hi"},"voice":{"name":"nobody"},"audioConfig":{"audioEncoding":"MP3"}}`, http.StatusBadRequest},
+		{"no encoding", http.MethodPost, `{"input":{"text":"hi"}}`, http.StatusBadRequest},
+		{"speaking rate", http.MethodPost, `{"input":{"text":"hi"},"audioConfig":{"audioEncoding":"MP3","speakingRate":9}}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(cloudSynthesizeHandler, tt.method, "/v1/text:synthesize", tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			var resp cloudErrorResp
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Error.Code != tt.status || resp.Error.Status == "" {
+				t.Errorf("body %s is not a Cloud error with code %d: %v", w.Body, tt.status, err)
+			}
+		})
+	}
+}
+
+func TestCloudVoicesHandler(t *testing.T) {
+	useVoices(t, "kore", "charon")
+
+	tests := []struct {
+		query  string
+		voices []string
+		langs  []string
+	}{
+		{"", []string{"charon", "kore"}, geminiLanguageCodes},
+		{"?languageCode=en", []string{"charon", "kore"}, []string{"en-IN", "en-US"}},
+		{"?languageCode=ja-jp", []string{"charon", "kore"}, []string{"ja-JP"}},
+		{"?languageCode=xx", nil, nil},
+	}
+	for _, tt := range tests {
+		w := serve(cloudVoicesHandler, http.MethodGet, "/v1/voices"+tt.query, "")
+		var resp cloudVoicesResp
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		var names []string
+		for _, v := range resp.Voices {
+			names = append(names, v.Name)
+			if !slices.Equal(v.LanguageCodes, tt.langs) {
+				t.Errorf("%s: %s speaks %v, want %v", tt.query, v.Name, v.LanguageCodes, tt.langs)
+			}
+		}
+		if !slices.Equal(names, tt.voices) {
+			t.Errorf("%s: voices %v, want %v", tt.query, names, tt.voices)
+		}
+	}
+}
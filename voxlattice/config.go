@@ -1,12 +1,32 @@
 package voxlattice
 
+import (
+	"sync/atomic"
+	"time"
+)
+
 const (
-	defaultModel       = "models/gemini-2.5-flash-native-audio-preview-12-2025"
-	defaultLogMaxBytes = 10 * 1024 * 1024
-	sampleRateHz       = 24000
-	channels           = 1
-	bitsPerSample      = 16
-	maxTextLen         = 10000
+	defaultModel           = "models/gemini-2.5-flash-native-audio-preview-12-2025"
+	defaultLogMaxMB        = 10
+	defaultLogMaxBackups   = 5
+	sampleRateHz           = 24000
+	channels               = 1
+	bitsPerSample          = 16
+	maxTextLen             = 100000
+	maxChunkLen            = 1000
+	chunkFadeMs            = 10
+	defaultChunkPauseMs    = 200
+	maxChunkPauseMs        = 5000
+	chunkTimeout           = 60 * time.Second
+	maxRequestTimeout      = 10 * time.Minute
+	defaultMP3BitrateKbps  = 64
+	defaultOpusBitrateKbps = 32
+	minOutputRateHz        = 4000
+	maxOutputRateHz        = 96000
+	wavUnknownLength       = 0xFFFFFFFF
+	defaultDialogueGapMs   = 400
+	maxDialogueGapMs       = 5000
+	maxNativeDialogueLen   = 4000
 )
 
 // Default voices (fallback when Voices.json missing or invalid)
@@ -43,19 +63,128 @@ var defaultSupportedVoices = map[string]string{
 	"justin":    "Justin - Male voice",
 }
 
-// Supported voice names for Gemini TTS (loaded at startup)
-var supportedVoices = map[string]string{}
+// Supported voice names for Gemini TTS, loaded at startup and replaced as
+// a whole when Voices.json changes (see currentVoices)
+var supportedVoices atomic.Pointer[map[string]string]
 
 type ttsReq struct {
 	Text  string `json:"text"`
+	SSML  string `json:"ssml,omitempty"` // <speak> document, instead of text
 	Voice string `json:"voice,omitempty"`
-	Lang  string `json:"lang,omitempty"` // Language code (e.g., "en-US", "zh-CN")
+	Lang  string `json:"lang,omitempty"`  // Language code (e.g., "en-US", "zh-CN")
+	Model string `json:"model,omitempty"` // Gemini model; empty means GEMINI_MODEL
+	// Output encoding ("wav", "mp3", "opus", "pcm_s16le", "mulaw", "alaw",
+	// "mulaw_wav", "alaw_wav"); empty means negotiate via Accept
+	Format     string `json:"format,omitempty"`
+	Bitrate    int    `json:"bitrate,omitempty"`     // MP3/Opus bitrate in kbps
+	SampleRate int    `json:"sample_rate,omitempty"` // Output rate in Hz for WAV/raw/G.711 formats
+	// Playback speed (0.25-4.0); the tempo changes, the pitch does not
+	Speed float64 `json:"speed,omitempty"`
+	// Silence between chunks of long texts in ms; 0 crossfades instead
+	ChunkPauseMs *int `json:"chunk_pause_ms,omitempty"`
+	// Word timings ("marks", "srt", "vtt") returned with the audio as
+	// multipart/mixed; a timing name in format returns the timings alone
+	TimingFormat string `json:"timing_format,omitempty"`
+	// Run as a background job and POST the outcome here when it is done
+	CallbackURL string `json:"callback_url,omitempty"`
+	// Hold streamed chunks back until their transcript passes
+	// --wer-threshold, so rejected audio can be retried unheard
+	VerifyTranscript bool `json:"verify_transcript,omitempty"`
+}
+
+type dialogueTurn struct {
+	Speaker string `json:"speaker"`
+	Text    string `json:"text"`
+}
+
+type dialogueReq struct {
+	Turns    []dialogueTurn    `json:"turns"`
+	Speakers map[string]string `json:"speakers"` // Speaker name -> voice
+	Lang     string            `json:"lang,omitempty"`
+	Model    string            `json:"model,omitempty"`
+	// "auto" (default) uses native multi-speaker synthesis when the model
+	// supports it, "native" requires it and "turns" voices each turn alone
+	Mode       string `json:"mode,omitempty"`
+	GapMs      *int   `json:"gap_ms,omitempty"` // Silence between turns in ms; 0 crossfades
+	Format     string `json:"format,omitempty"`
+	Bitrate    int    `json:"bitrate,omitempty"`
+	SampleRate int    `json:"sample_rate,omitempty"`
+}
+
+// OpenAI /v1/audio/speech request body
+type openAISpeechReq struct {
+	Model          string   `json:"model"`
+	Input          string   `json:"input"`
+	Voice          string   `json:"voice"`
+	ResponseFormat string   `json:"response_format,omitempty"` // mp3 (default), opus, wav or pcm
+	Speed          *float64 `json:"speed,omitempty"`
+	Instructions   string   `json:"instructions,omitempty"` // Accepted but not used
+}
+
+// OpenAI error envelope
+type openAIErrorResp struct {
+	Error openAIError `json:"error"`
+}
+
+type openAIError struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    *string `json:"code"`
+}
+
+// Cloud Text-to-Speech v1 text:synthesize request body
+type cloudSynthesizeReq struct {
+	Input struct {
+		Text string `json:"text,omitempty"`
+		SSML string `json:"ssml,omitempty"`
+	} `json:"input"`
+	Voice struct {
+		LanguageCode string `json:"languageCode,omitempty"`
+		Name         string `json:"name,omitempty"`
+		SSMLGender   string `json:"ssmlGender,omitempty"` // Accepted but not used
+	} `json:"voice"`
+	AudioConfig struct {
+		AudioEncoding   string  `json:"audioEncoding"`
+		SampleRateHertz int     `json:"sampleRateHertz,omitempty"`
+		SpeakingRate    float64 `json:"speakingRate,omitempty"`
+		Pitch           float64 `json:"pitch,omitempty"`        // Accepted but not used
+		VolumeGainDb    float64 `json:"volumeGainDb,omitempty"` // Accepted but not used
+	} `json:"audioConfig"`
+}
+
+type cloudSynthesizeResp struct {
+	AudioContent []byte `json:"audioContent"` // base64 in JSON
+}
+
+type cloudVoice struct {
+	LanguageCodes          []string `json:"languageCodes"`
+	Name                   string   `json:"name"`
+	SSMLGender             string   `json:"ssmlGender"`
+	NaturalSampleRateHertz int      `json:"naturalSampleRateHertz"`
+}
+
+type cloudVoicesResp struct {
+	Voices []cloudVoice `json:"voices"`
+}
+
+// Google API error envelope
+type cloudErrorResp struct {
+	Error cloudError `json:"error"`
+}
+
+type cloudError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
 }
 
 type healthResp struct {
 	Status  string            `json:"status"`
 	Model   string            `json:"model"`
+	Backend string            `json:"backend"`
 	Voices  map[string]string `json:"voices"`
+	Formats []string          `json:"formats"` // Output formats this build can produce
 	Message string            `json:"message,omitempty"`
 }
 
@@ -77,3 +206,48 @@ type voicesMapEnvelope struct {
 	GeneratedAt string            `json:"generated_at"`
 	Voices      map[string]string `json:"voices"`
 }
+
+// /jobs status; items are reported in submission order
+type jobResp struct {
+	ID         string        `json:"id"`
+	Status     string        `json:"status"` // queued, running, succeeded, failed, canceled
+	CreatedAt  time.Time     `json:"created_at"`
+	StartedAt  *time.Time    `json:"started_at,omitempty"`
+	FinishedAt *time.Time    `json:"finished_at,omitempty"`
+	Total      int           `json:"total"`
+	Completed  int           `json:"completed"`
+	Failed     int           `json:"failed"`
+	Progress   float64       `json:"progress"` // share of items that are done, 0-1
+	Items      []jobItemResp `json:"items"`
+}
+
+type jobItemResp struct {
+	Index       int    `json:"index"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Bytes       int    `json:"bytes,omitempty"`
+}
+
+// Body of the POST sent to a job's callback_url
+type callbackPayload struct {
+	Event      string     `json:"event"` // job.succeeded or job.failed
+	JobID      string     `json:"job_id"`
+	Status     string     `json:"status"`
+	StatusURL  string     `json:"status_url"`
+	ResultURL  string     `json:"result_url,omitempty"` // set when any item succeeded
+	Total      int        `json:"total"`
+	Completed  int        `json:"completed"`
+	Failed     int        `json:"failed"`
+	Error      string     `json:"error,omitempty"` // first item error, if any
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+// One line of jobs/dead-letters.jsonl
+type deadLetter struct {
+	Time        time.Time       `json:"time"`
+	CallbackURL string          `json:"callback_url"`
+	Attempts    int             `json:"attempts"`
+	Error       string          `json:"error"`
+	Payload     callbackPayload `json:"payload"`
+}
@@ -0,0 +1,247 @@
+package voxlattice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func parseDialogueRequest(r *http.Request) (dialogueReq, error) {
+	var out dialogueReq
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return out, err
+	}
+	err = json.Unmarshal(body, &out)
+	return out, err
+}
+
+// validateDialogue normalizes the turns and speaker map of req into a
+// dialogueRequest. Speakers that never talk are dropped.
+func validateDialogue(req dialogueReq) (dialogueRequest, error) {
+	var out dialogueRequest
+	if len(req.Turns) == 0 {
+		return out, errors.New("turns is required")
+	}
+
+	voices := make(map[string]string, len(req.Speakers))
+	for name, voice := range req.Speakers {
+		name = strings.TrimSpace(name)
+		if name == "" || strings.ContainsAny(name, ":\r\n") {
+			return out, fmt.Errorf("invalid speaker name: %q", name)
+		}
+		voice = strings.ToLower(strings.TrimSpace(voice))
+		if voice == "" {
+			return out, fmt.Errorf("speaker %s has no voice", name)
+		}
+		if _, exists := currentVoices()[voice]; !exists {
+			return out, fmt.Errorf("unsupported voice for speaker %s: %s, supported voices: %v", name, voice, getSupportedVoiceNames())
+		}
+		voices[name] = voice
+	}
+
+	out.Voices = map[string]string{}
+	total := 0
+	for i, turn := range req.Turns {
+		speaker := strings.TrimSpace(turn.Speaker)
+		voice, ok := voices[speaker]
+		if !ok {
+			return out, fmt.Errorf("turn %d: unknown speaker: %q", i+1, turn.Speaker)
+		}
+		text, err := normalizeText(turn.Text)
+		if err != nil {
+			return out, fmt.Errorf("turn %d: %w", i+1, err)
+		}
+		total += len(text)
+		out.Turns = append(out.Turns, dialogueTurn{Speaker: speaker, Text: text})
+		out.Voices[speaker] = voice
+	}
+	if total > maxTextLen {
+		return out, fmt.Errorf("text too long: %d > %d", total, maxTextLen)
+	}
+	return out, nil
+}
+
+// dialogueSpeakers lists the speakers of turns in order of first appearance.
+func dialogueSpeakers(turns []dialogueTurn) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, t := range turns {
+		if !seen[t.Speaker] {
+			seen[t.Speaker] = true
+			names = append(names, t.Speaker)
+		}
+	}
+	return names
+}
+
+// synthesizeTurns voices each turn separately with its speaker's voice and
+// joins them with gapMs of silence, or a crossfade when gapMs is 0.
+func synthesizeTurns(ctx context.Context, req dialogueRequest, gapMs int, emit func([]byte) error) error {
+	st := newPCMStitcher(emit, chunkFadeMs, gapMs)
+	for i, turn := range req.Turns {
+		if err := st.startChunk(); err != nil {
+			return err
+		}
+		part := synthRequest{
+			Text:   turn.Text,
+			Voice:  req.Voices[turn.Speaker],
+			Lang:   req.Lang,
+			Model:  req.Model,
+			APIKey: req.APIKey,
+		}
+		chunks := splitText(turn.Text, maxChunkLen)
+		if err := synthesizeChunks(ctx, part, chunks, defaultChunkPauseMs, st.write); err != nil {
+			return fmt.Errorf("turn %d/%d (%s): %w", i+1, len(req.Turns), turn.Speaker, err)
+		}
+	}
+	return st.finish()
+}
+
+func dialogueHandler(w http.ResponseWriter, r *http.Request) {
+	// Allow CORS for web access
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, X-Gemini-Api-Key")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req, err := parseDialogueRequest(r)
+	if err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	dreq, err := validateDialogue(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dreq.Lang = req.Lang
+
+	dreq.Model, err = resolveModel(req.Model)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format, opts, err := resolveOutput(r, ttsReq{Format: req.Format, Bitrate: req.Bitrate, SampleRate: req.SampleRate})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	gapMs := defaultDialogueGapMs
+	if req.GapMs != nil {
+		gapMs = *req.GapMs
+	}
+	if gapMs < 0 || gapMs > maxDialogueGapMs {
+		http.Error(w, fmt.Sprintf("gap_ms must be between 0 and %d", maxDialogueGapMs), http.StatusBadRequest)
+		return
+	}
+
+	native := false
+	multi, canMulti := activeBackend.(dialogueSynthesizer)
+	switch strings.ToLower(strings.TrimSpace(req.Mode)) {
+	case "", "auto":
+		native = canMulti && multi.supportsDialogue(dreq)
+	case "native":
+		if !canMulti || !multi.supportsDialogue(dreq) {
+			http.Error(w, fmt.Sprintf("native dialogue needs a TTS model, exactly 2 speakers and at most %d bytes of script", maxNativeDialogueLen), http.StatusBadRequest)
+			return
+		}
+		native = true
+	case "turns":
+	default:
+		http.Error(w, fmt.Sprintf("unsupported mode: %s, supported modes: [auto native turns]", req.Mode), http.StatusBadRequest)
+		return
+	}
+
+	script := ""
+	for _, turn := range dreq.Turns {
+		script += turn.Text
+	}
+	voices := make([]string, 0, len(dreq.Voices))
+	for _, voice := range dreq.Voices {
+		voices = append(voices, voice)
+	}
+	auth, err := resolveAPIKey(r)
+	if err == nil {
+		err = auth.checkAccess(dreq.Model, voices...)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), authErrorStatus(err))
+		return
+	}
+	adm, err := admitRequest(r, auth, script)
+	if err != nil {
+		setRetryAfter(w, err)
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	defer adm.done()
+	noteSpeech(r, "", script)
+	dreq.APIKey = auth.apiKey
+
+	if native {
+		w.Header().Set("X-Dialogue-Mode", "native")
+	} else {
+		w.Header().Set("X-Dialogue-Mode", "turns")
+	}
+	key := dialogueKey(dreq, format, opts, native, gapMs)
+	if audioCache != nil {
+		if audio, ok := audioCache.Get(key); ok {
+			w.Header().Set("X-Cache", "HIT")
+			writeAudio(w, format, opts, audio)
+			return
+		}
+		w.Header().Set("X-Cache", "MISS")
+	}
+	if err := adm.charge(r); err != nil {
+		setRetryAfter(w, err)
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+
+	chunks := 0
+	for _, turn := range dreq.Turns {
+		chunks += len(splitText(turn.Text, maxChunkLen))
+	}
+	timeout := chunksTimeout(chunks)
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout + 5*time.Second))
+	reqLog(r).Debugf("tts dialogue: %d turns, %d speakers, native=%v", len(dreq.Turns), len(dreq.Voices), native)
+
+	audio, _, err := synthesizeBuffered(r.Context(), key, auth.apiKey, timeout, format, opts, func(ctx context.Context, emit func([]byte) error) error {
+		if native {
+			ctx, emit, done := observeUpstream(ctx, dreq.Model, emit,
+				attribute.String("tts.model", strings.TrimPrefix(dreq.Model, "models/")),
+				attribute.Int("tts.turns", len(dreq.Turns)),
+				attribute.Int("tts.text_length", len([]rune(script))),
+			)
+			err := multi.synthesizeDialogue(ctx, dreq, emit)
+			done(err)
+			return err
+		}
+		return synthesizeTurns(ctx, dreq, gapMs, emit)
+	})
+	if err != nil {
+		adm.refund()
+		writeSynthError(w, r, err)
+		return
+	}
+	writeAudio(w, format, opts, audio)
+}
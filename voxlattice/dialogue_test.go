@@ -0,0 +1,71 @@
+package voxlattice
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDialogueHandler(t *testing.T) {
+	useFakeBackend(t)
+	useVoices(t, "kore", "charon")
+
+	script := func(gapMs int) string {
+		return fmt.Sprintf(`{"speakers":{"A":"kore","B":"charon"},"turns":[{"speaker":"A","text":"hi"},{"speaker":"B","text":"yo"}],"format":"pcm_s16le","gap_ms":%d}`, gapMs)
+	}
+	lengths := map[int]int{}
+	for _, gapMs := range []int{0, 400} {
+		w := serve(dialogueHandler, http.MethodPost, "/tts/dialogue", script(gapMs))
+		if w.Code != http.StatusOK {
+			t.Fatalf("gap %d: status %d: %s", gapMs, w.Code, w.Body)
+		}
+		if mode := w.Header().Get("X-Dialogue-Mode"); mode != "turns" {
+			t.Errorf("gap %d: X-Dialogue-Mode %q, want turns", gapMs, mode)
+		}
+		lengths[gapMs] = w.Body.Len()
+	}
+	turns := fakePCMBytes("hi") + fakePCMBytes("yo")
+	gap := 400 * sampleRateHz / 1000 * bitsPerSample / 8
+	fade := 2 * chunkFadeMs * sampleRateHz / 1000 * bitsPerSample / 8
+	if got := lengths[0]; got > turns || got < turns-fade {
+		t.Errorf("crossfaded turns are %d bytes, want about %d", got, turns)
+	}
+	if got := lengths[400] - lengths[0]; got < gap-fade || got > gap+fade {
+		t.Errorf("a 400ms gap adds %d bytes, want about %d", got, gap)
+	}
+}
+
+func TestDialogueHandlerErrors(t *testing.T) {
+	useFakeBackend(t)
+	useVoices(t, "kore", "charon")
+
+	speakers := `"speakers":{"A":"kore","B":"charon"}`
+	turns := `"turns":[{"speaker":"A","text":"hi"},{"speaker":"B","text":"yo"}]`
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"bad json", `{"turns":`},
+		{"no turns", `{` + speakers + `}`},
+		{"no speakers", `{` + turns + `}`},
+		{"unknown speaker", `{` + speakers + `,"turns":[{"speaker":"C","text":"hi"}]}`},
+		{"speaker without voice", `{"speakers":{"A":"kore","B":""},` + turns + `}`},
+		{"unknown voice", `{"speakers":{"A":"kore","B":"nobody"},` + turns + `}`},
+		{"empty turn", `{` + speakers + `,"turns":[{"speaker":"A","text":"  "}]}`},
+		{"gap", `{` + speakers + `,` + turns + `,"gap_ms":` + fmt.Sprint(maxDialogueGapMs+1) + `}`},
+		{"native without support", `{` + speakers + `,` + turns + `,"mode":"native"}`},
+		{"unknown mode", `{` + speakers + `,` + turns + `,"mode":"chorus"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(dialogueHandler, http.MethodPost, "/tts/dialogue", tt.body)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status %d, want %d: %s", w.Code, http.StatusBadRequest, strings.TrimSpace(w.Body.String()))
+			}
+		})
+	}
+	if w := serve(dialogueHandler, http.MethodGet, "/tts/dialogue", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET status %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,378 @@
+package voxlattice
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// pcmEncoder turns a stream of raw PCM chunks into an encoded audio stream.
+type pcmEncoder interface {
+	Write(pcm []byte) error
+	Close() error
+}
+
+type encodeOptions struct {
+	bitrateKbps int
+	sampleRate  int // output rate; the source is always sampleRateHz
+}
+
+type audioFormat struct {
+	name        string
+	contentType string
+	// raw formats carry no header, so the rate is added to the media type.
+	raw bool
+	// defaultRate is used when the request does not ask for a rate (0 = source rate).
+	defaultRate int
+	// fixedRate formats cannot be resampled.
+	fixedRate bool
+	// check validates the options before any synthesis work starts.
+	check func(opts encodeOptions) error
+	// encode converts a complete PCM buffer in one go; nil means use stream.
+	encode func(pcm []byte, opts encodeOptions) ([]byte, error)
+	// stream returns an encoder suitable for chunked responses.
+	stream func(w io.Writer, opts encodeOptions) (pcmEncoder, error)
+}
+
+var audioFormats = map[string]audioFormat{
+	"wav":       wavAudioFormat("wav", codecPCM16, 0),
+	"mulaw_wav": wavAudioFormat("mulaw_wav", codecMulaw, 8000),
+	"alaw_wav":  wavAudioFormat("alaw_wav", codecAlaw, 8000),
+	"pcm_s16le": rawAudioFormat("pcm_s16le", "audio/pcm", codecPCM16, 0),
+	"mulaw":     rawAudioFormat("mulaw", "audio/PCMU", codecMulaw, 8000),
+	"alaw":      rawAudioFormat("alaw", "audio/PCMA", codecAlaw, 8000),
+	"mp3": {
+		name:        "mp3",
+		contentType: "audio/mpeg",
+		fixedRate:   true,
+		check:       checkMP3Options,
+		stream:      newMP3Encoder,
+	},
+	"opus": {
+		name:        "opus",
+		contentType: "audio/ogg; codecs=opus",
+		fixedRate:   true,
+		check:       checkOpusOptions,
+		stream:      newOggOpusEncoder,
+	},
+}
+
+// Accept header media types mapped to output format names
+var acceptFormats = map[string]string{
+	"audio/wav":   "wav",
+	"audio/wave":  "wav",
+	"audio/x-wav": "wav",
+	"audio/mpeg":  "mp3",
+	"audio/mp3":   "mp3",
+	"audio/ogg":   "opus",
+	"audio/opus":  "opus",
+	"audio/basic": "mulaw",
+	"audio/pcmu":  "mulaw",
+	"audio/pcma":  "alaw",
+	"audio/pcm":   "pcm_s16le",
+}
+
+// resolveOutput picks the output format from the request body "format" field,
+// falling back to the Accept header and finally to WAV.
+func resolveOutput(r *http.Request, req ttsReq) (audioFormat, encodeOptions, error) {
+	return resolveFormat(r.Header.Get("Accept"), req)
+}
+
+// resolveFormat is resolveOutput for an already extracted Accept header.
+func resolveFormat(accept string, req ttsReq) (audioFormat, encodeOptions, error) {
+	name := strings.ToLower(strings.TrimSpace(req.Format))
+	if name == "" {
+		name = formatFromAccept(accept)
+	}
+	if name == "" {
+		name = "wav"
+	}
+	format, ok := audioFormats[name]
+	if !ok {
+		return audioFormat{}, encodeOptions{}, fmt.Errorf("unsupported format: %s", name)
+	}
+
+	opts := encodeOptions{bitrateKbps: req.Bitrate, sampleRate: req.SampleRate}
+	if opts.sampleRate == 0 {
+		opts.sampleRate = format.defaultRate
+	}
+	if opts.sampleRate == 0 {
+		opts.sampleRate = sampleRateHz
+	}
+	if opts.sampleRate < minOutputRateHz || opts.sampleRate > maxOutputRateHz {
+		return audioFormat{}, encodeOptions{}, fmt.Errorf("unsupported sample_rate: %d, supported: %d-%d", opts.sampleRate, minOutputRateHz, maxOutputRateHz)
+	}
+	if format.fixedRate && opts.sampleRate != sampleRateHz {
+		return audioFormat{}, encodeOptions{}, fmt.Errorf("%s output only supports sample_rate %d", format.name, sampleRateHz)
+	}
+	if format.check != nil {
+		if err := format.check(opts); err != nil {
+			return audioFormat{}, encodeOptions{}, err
+		}
+	}
+	return format, opts, nil
+}
+
+func formatFromAccept(accept string) string {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if name, ok := acceptFormats[mediaType]; ok && formatAvailable(name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// formatAvailable reports whether this build can produce format name;
+// Opus needs a build with libopus.
+func formatAvailable(name string) bool {
+	return name != "opus" || opusSupported
+}
+
+// availableFormats lists the output formats this build can produce.
+func availableFormats() []string {
+	names := make([]string, 0, len(audioFormats))
+	for name := range audioFormats {
+		if formatAvailable(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// supportedNames lists the keys of a request format table whose formats
+// this build can produce, for error messages.
+func supportedNames(formats map[string]string, order ...string) string {
+	var names []string
+	for _, name := range order {
+		if formatAvailable(formats[name]) {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// mediaType returns the Content-Type for the given options.
+func (f audioFormat) mediaType(opts encodeOptions) string {
+	if f.raw {
+		return fmt.Sprintf("%s; rate=%d", f.contentType, opts.sampleRate)
+	}
+	return f.contentType
+}
+
+// Encode resamples a complete 24 kHz PCM buffer to the requested rate and
+// encodes it.
+func (f audioFormat) Encode(pcm []byte, opts encodeOptions) ([]byte, error) {
+	if opts.sampleRate != sampleRateHz {
+		pcm = resamplePCM(pcm, sampleRateHz, opts.sampleRate)
+	}
+	if f.encode != nil {
+		return f.encode(pcm, opts)
+	}
+	var buf bytes.Buffer
+	enc, err := f.stream(&buf, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := enc.Write(pcm); err != nil {
+		_ = enc.Close()
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Stream returns an encoder that accepts 24 kHz PCM chunks, resampling them
+// on the fly when another rate was requested.
+func (f audioFormat) Stream(w io.Writer, opts encodeOptions) (pcmEncoder, error) {
+	enc, err := f.stream(w, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.sampleRate == sampleRateHz {
+		return enc, nil
+	}
+	return &resamplingEncoder{rs: newResampler(sampleRateHz, opts.sampleRate), next: enc}, nil
+}
+
+// resamplingEncoder converts PCM to the output rate before handing it on.
+type resamplingEncoder struct {
+	rs      *resampler
+	next    pcmEncoder
+	pending []byte
+}
+
+func (e *resamplingEncoder) Write(pcm []byte) error {
+	e.pending = append(e.pending, pcm...)
+	n := len(e.pending) &^ 1
+	out := e.rs.process(pcmToSamples(e.pending[:n]), false)
+	e.pending = append(e.pending[:0], e.pending[n:]...)
+	if len(out) == 0 {
+		return nil
+	}
+	return e.next.Write(samplesToPCM(out))
+}
+
+func (e *resamplingEncoder) Close() error {
+	if out := e.rs.process(nil, true); len(out) > 0 {
+		if err := e.next.Write(samplesToPCM(out)); err != nil {
+			_ = e.next.Close()
+			return err
+		}
+	}
+	return e.next.Close()
+}
+
+// pcmToSamples decodes little-endian 16-bit PCM; a trailing odd byte is dropped.
+func pcmToSamples(pcm []byte) []int16 {
+	out := make([]int16, len(pcm)/2)
+	for i := range out {
+		out[i] = int16(binary.LittleEndian.Uint16(pcm[i*2:]))
+	}
+	return out
+}
+
+func samplesToPCM(samples []int16) []byte {
+	out := make([]byte, len(samples)*2)
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(out[i*2:], uint16(s))
+	}
+	return out
+}
+
+// sampleCodec describes how 16-bit PCM is stored in the output.
+type sampleCodec struct {
+	wavFormat uint16
+	bits      int
+	// convert maps 16-bit PCM to the stored representation; nil keeps it as is.
+	convert func(pcm []byte) []byte
+}
+
+const (
+	wavFormatPCM   = 1
+	wavFormatALaw  = 6
+	wavFormatMuLaw = 7
+)
+
+var (
+	codecPCM16 = sampleCodec{wavFormat: wavFormatPCM, bits: 16}
+	codecMulaw = sampleCodec{wavFormat: wavFormatMuLaw, bits: 8, convert: pcmToMulaw}
+	codecAlaw  = sampleCodec{wavFormat: wavFormatALaw, bits: 8, convert: pcmToAlaw}
+)
+
+func (c sampleCodec) apply(pcm []byte) []byte {
+	if c.convert == nil {
+		return pcm
+	}
+	return c.convert(pcm)
+}
+
+func wavAudioFormat(name string, codec sampleCodec, defaultRate int) audioFormat {
+	return audioFormat{
+		name:        name,
+		contentType: "audio/wav",
+		defaultRate: defaultRate,
+		encode: func(pcm []byte, opts encodeOptions) ([]byte, error) {
+			return pcmToWav(pcm, codec, opts.sampleRate)
+		},
+		stream: func(w io.Writer, opts encodeOptions) (pcmEncoder, error) {
+			if err := writeWavHeader(w, codec, opts.sampleRate, wavUnknownLength); err != nil {
+				return nil, err
+			}
+			return &rawEncoder{w: w, codec: codec}, nil
+		},
+	}
+}
+
+func rawAudioFormat(name, contentType string, codec sampleCodec, defaultRate int) audioFormat {
+	return audioFormat{
+		name:        name,
+		contentType: contentType,
+		raw:         true,
+		defaultRate: defaultRate,
+		stream: func(w io.Writer, _ encodeOptions) (pcmEncoder, error) {
+			return &rawEncoder{w: w, codec: codec}, nil
+		},
+	}
+}
+
+// rawEncoder writes samples without any framing.
+type rawEncoder struct {
+	w     io.Writer
+	codec sampleCodec
+}
+
+func (e *rawEncoder) Write(pcm []byte) error {
+	_, err := e.w.Write(e.codec.apply(pcm))
+	return err
+}
+
+func (e *rawEncoder) Close() error { return nil }
+
+func pcmToWav(pcm []byte, codec sampleCodec, rate int) ([]byte, error) {
+	data := codec.apply(pcm)
+	buf := &bytes.Buffer{}
+	if err := writeWavHeader(buf, codec, rate, uint32(len(data))); err != nil {
+		return nil, err
+	}
+	buf.Write(data)
+
+	return buf.Bytes(), nil
+}
+
+// writeWavHeader writes a WAV header for dataLen bytes of audio. PCM uses the
+// canonical 44-byte layout; G.711 adds cbSize and the fact chunk that non-PCM
+// formats require. Passing wavUnknownLength produces an open-ended header.
+func writeWavHeader(w io.Writer, codec sampleCodec, rate int, dataLen uint32) error {
+	blockAlign := channels * codec.bits / 8
+	byteRate := rate * blockAlign
+	fmtLen := uint32(16)
+	if codec.wavFormat != wavFormatPCM {
+		fmtLen = 18
+	}
+	riffLen := dataLen
+	if dataLen != wavUnknownLength {
+		riffLen = 4 + 8 + fmtLen + 8 + dataLen
+		if codec.wavFormat != wavFormatPCM {
+			riffLen += 12
+		}
+	}
+
+	fields := []interface{}{
+		[]byte("RIFF"),
+		riffLen,
+		[]byte("WAVEfmt "),
+		fmtLen,
+		codec.wavFormat,
+		uint16(channels),
+		uint32(rate),
+		uint32(byteRate),
+		uint16(blockAlign),
+		uint16(codec.bits),
+	}
+	if codec.wavFormat != wavFormatPCM {
+		sampleCount := dataLen
+		if dataLen != wavUnknownLength {
+			sampleCount = dataLen / uint32(blockAlign)
+		}
+		fields = append(fields, uint16(0), []byte("fact"), uint32(4), sampleCount)
+	}
+	fields = append(fields, []byte("data"), dataLen)
+	for _, f := range fields {
+		if err := binary.Write(w, binary.LittleEndian, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
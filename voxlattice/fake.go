@@ -0,0 +1,100 @@
+package voxlattice
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"unicode"
+)
+
+const (
+	fakeRuneMs  = 60
+	fakeFadeMs  = 5
+	fakeChunkMs = 100
+	fakeLevel   = 0.3 * math.MaxInt16
+)
+
+// fakeSynthesizer is an offline backend for tests and local development. It
+// turns every letter or digit into a short rising sine sweep and everything
+// else into silence, so the audio is deterministic and its length grows with
+// the text. The voice picks the base pitch.
+type fakeSynthesizer struct{}
+
+func (fakeSynthesizer) requiresAPIKey() bool { return false }
+
+func (fakeSynthesizer) transcribes(string) bool { return true }
+
+func (fakeSynthesizer) synthesize(ctx context.Context, req synthRequest, emit func([]byte) error) error {
+	base := fakeBaseFreq(req.Voice)
+	segSamples := sampleRateHz * fakeRuneMs / 1000
+	fadeSamples := sampleRateHz * fakeFadeMs / 1000
+	chunkBytes := sampleRateHz * fakeChunkMs / 1000 * bitsPerSample / 8
+
+	buf := make([]byte, 0, chunkBytes+segSamples*2)
+	// With a transcript requested, every word is emitted and reported as
+	// soon as it ends so its timing is exact.
+	var word []rune
+	endWord := func() error {
+		if req.onTranscript == nil || len(word) == 0 {
+			return nil
+		}
+		if err := emit(buf); err != nil {
+			return err
+		}
+		buf = make([]byte, 0, chunkBytes+segSamples*2)
+		req.onTranscript(string(word) + " ")
+		word = word[:0]
+		return nil
+	}
+	for _, r := range req.Text {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if unicode.IsSpace(r) {
+			if err := endWord(); err != nil {
+				return err
+			}
+		} else {
+			word = append(word, r)
+		}
+		voiced := unicode.IsLetter(r) || unicode.IsDigit(r)
+		// Map each rune onto a semitone above the base pitch.
+		f0 := base * math.Pow(2, float64(r%12)/12)
+		phase := 0.0
+		for i := 0; i < segSamples; i++ {
+			var v float64
+			if voiced {
+				t := float64(i) / float64(segSamples)
+				phase += 2 * math.Pi * f0 * (1 + 0.25*t) / sampleRateHz
+				gain := 1.0
+				if i < fadeSamples {
+					gain = float64(i) / float64(fadeSamples)
+				} else if segSamples-i <= fadeSamples {
+					gain = float64(segSamples-i-1) / float64(fadeSamples)
+				}
+				v = fakeLevel * gain * math.Sin(phase)
+			}
+			buf = binary.LittleEndian.AppendUint16(buf, uint16(int16(v)))
+		}
+		if len(buf) >= chunkBytes {
+			if err := emit(buf); err != nil {
+				return err
+			}
+			buf = make([]byte, 0, chunkBytes+segSamples*2)
+		}
+	}
+	if err := endWord(); err != nil {
+		return err
+	}
+	if len(buf) > 0 {
+		return emit(buf)
+	}
+	return nil
+}
+
+func fakeBaseFreq(voice string) float64 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(voice))
+	return 120 + float64(h.Sum32()%120)
+}
@@ -0,0 +1,249 @@
+package voxlattice
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Set from --wer-threshold and --wer-retries. A threshold of 0 only
+// measures the word error rate; above it a chunk is synthesized again up
+// to fidelityRetries times before the request fails.
+var (
+	fidelityThreshold float64
+	fidelityRetries   = 1
+)
+
+// fidelityReport sums the word errors of every chunk of one synthesis.
+// Chunks the backend should have transcribed but did not count as
+// unverified.
+type fidelityReport struct {
+	errors     int
+	words      int
+	unverified int
+}
+
+func (f *fidelityReport) add(errors, words int) {
+	if f == nil {
+		return
+	}
+	f.errors += errors
+	f.words += words
+}
+
+// wer returns the word error rate over everything checked and whether
+// anything was.
+func (f *fidelityReport) wer() (float64, bool) {
+	if f == nil || f.words == 0 {
+		return 0, false
+	}
+	return float64(f.errors) / float64(f.words), true
+}
+
+func (f *fidelityReport) missed() {
+	if f != nil {
+		f.unverified++
+	}
+}
+
+func (f *fidelityReport) log(l *appLogger) {
+	if f != nil && f.unverified > 0 {
+		l.Warnf("tts transcript missing for %d chunks, word error rate unverified", f.unverified)
+		return
+	}
+	if wer, ok := f.wer(); ok {
+		l.Infof("tts transcript word error rate %.3f over %d words", wer, f.words)
+	}
+}
+
+// reportFidelity logs the word error rate of a synthesis this request ran
+// and returns it in the X-Transcript-WER header, or "unverified" when a
+// transcript that should have come did not. Cache hits and shared
+// syntheses have nothing to report.
+func reportFidelity(w http.ResponseWriter, r *http.Request, f *fidelityReport) {
+	if f != nil && f.unverified > 0 {
+		f.log(reqLog(r))
+		w.Header().Set("X-Transcript-WER", "unverified")
+		return
+	}
+	if wer, ok := f.wer(); ok {
+		f.log(reqLog(r))
+		w.Header().Set("X-Transcript-WER", strconv.FormatFloat(wer, 'f', 3, 64))
+	}
+}
+
+// fidelityError reports audio whose transcript strays too far from the
+// text, or that came without the transcript needed to tell.
+type fidelityError struct {
+	wer        float64
+	threshold  float64
+	transcript string
+	missing    bool
+}
+
+func (e *fidelityError) Error() string {
+	if e.missing {
+		return fmt.Sprintf("transcript missing: cannot check word error rate against %.2f", e.threshold)
+	}
+	return fmt.Sprintf("transcript mismatch: word error rate %.2f above %.2f (heard %q)", e.wer, e.threshold, e.transcript)
+}
+
+// code is the machine-readable error code sent with the error.
+func (e *fidelityError) code() string {
+	if e.missing {
+		return "transcript_missing"
+	}
+	return "transcript_mismatch"
+}
+
+// partEvent is audio or transcript text held back while a chunk is checked.
+type partEvent struct {
+	pcm  []byte
+	text string
+}
+
+// speakChecked synthesizes one chunk into emit and compares the backend's
+// transcription of it with the text. With retries enabled the chunk is
+// held back until it passes, so rejected audio never reaches emit, unless
+// req passes audio through. heard tells whether the backend transcribed
+// anything at all; a backend that should have but did not fails the check
+// when a threshold is set.
+func speakChecked(ctx context.Context, req synthRequest, emit func([]byte) error) (heard bool, err error) {
+	buffered := fidelityThreshold > 0 && fidelityRetries > 0 && !req.passThrough
+	expected := backendTranscribes(req.Model) && len(werTokens(req.Text)) > 0
+	attempts := 1
+	if buffered {
+		attempts += fidelityRetries
+	}
+	for attempt := 1; ; attempt++ {
+		var said strings.Builder
+		var events []partEvent
+		heard = false
+
+		try := req
+		try.onTranscript = func(text string) {
+			heard = true
+			said.WriteString(text)
+			if buffered {
+				events = append(events, partEvent{text: text})
+			} else if req.onTranscript != nil {
+				req.onTranscript(text)
+			}
+		}
+		sink := emit
+		if buffered {
+			sink = func(pcm []byte) error {
+				if len(pcm) == 0 {
+					return nil
+				}
+				events = append(events, partEvent{pcm: append([]byte(nil), pcm...)})
+				return nil
+			}
+		}
+		callCtx, sink, done := observeUpstream(ctx, req.Model, sink, speechAttrs(req)...)
+		err := activeBackend.synthesize(callCtx, try, sink)
+		done(err)
+		if err != nil {
+			return heard, err
+		}
+
+		wer, errs, words := 0.0, 0, 0
+		if heard {
+			errs, words = wordErrors(req.Text, said.String())
+			if words > 0 {
+				wer = float64(errs) / float64(words)
+			}
+			logFrom(ctx).Debugf("tts transcript word error rate %.3f (%d/%d words)", wer, errs, words)
+		}
+		missing := expected && !heard
+		passed := fidelityThreshold <= 0 || (heard && wer <= fidelityThreshold) || (!heard && !expected)
+		if !passed && attempt < attempts {
+			if missing {
+				logFrom(ctx).Warnf("tts transcript missing, retrying (%d/%d)", attempt, fidelityRetries)
+			} else {
+				logFrom(ctx).Warnf("tts transcript word error rate %.2f above %.2f, retrying (%d/%d): %q", wer, fidelityThreshold, attempt, fidelityRetries, said.String())
+			}
+			continue
+		}
+		req.fidelity.add(errs, words)
+		if missing {
+			req.fidelity.missed()
+		}
+		if !passed {
+			if missing {
+				logFrom(ctx).Warnf("tts transcript missing, giving up")
+				return heard, &fidelityError{threshold: fidelityThreshold, missing: true}
+			}
+			logFrom(ctx).Warnf("tts transcript word error rate %.2f above %.2f, giving up: %q", wer, fidelityThreshold, said.String())
+			return heard, &fidelityError{wer: wer, threshold: fidelityThreshold, transcript: said.String()}
+		}
+		for _, ev := range events {
+			if ev.pcm != nil {
+				if err := emit(ev.pcm); err != nil {
+					return heard, err
+				}
+			} else if req.onTranscript != nil {
+				req.onTranscript(ev.text)
+			}
+		}
+		return heard, nil
+	}
+}
+
+// wordErrors returns the word-level edit distance between the transcript
+// and the text it should match, and the number of words in the text. Case
+// and punctuation are ignored; scripts without spaces count per character.
+func wordErrors(text, transcript string) (errors, words int) {
+	ref := werTokens(text)
+	hyp := werTokens(transcript)
+	if len(ref) == 0 {
+		return 0, 0
+	}
+	prev := make([]int, len(hyp)+1)
+	cur := make([]int, len(hyp)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ref); i++ {
+		cur[0] = i
+		for j := 1; j <= len(hyp); j++ {
+			cost := 1
+			if ref[i-1] == hyp[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(hyp)], len(ref)
+}
+
+func werTokens(s string) []string {
+	var out []string
+	var word []rune
+	flush := func() {
+		if len(word) > 0 {
+			out = append(out, string(word))
+			word = word[:0]
+		}
+	}
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case isCJKRune(r) && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			// isCJKRune also covers full-width punctuation, which is skipped
+			flush()
+			out = append(out, string(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r):
+			word = append(word, r)
+		case r == '\'' || r == '’':
+			// "don't" and "dont" are the same word
+		default:
+			flush()
+		}
+	}
+	flush()
+	return out
+}
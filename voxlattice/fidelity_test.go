@@ -0,0 +1,141 @@
+package voxlattice
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestWerTokens(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"Hello, World!", []string{"hello", "world"}},
+		{"Don't stop", []string{"dont", "stop"}},
+		{"It’s 42.5%", []string{"its", "42", "5"}},
+		{"你好，世界", []string{"你", "好", "世", "界"}},
+		{"Go语言 rocks", []string{"go", "语", "言", "rocks"}},
+		{"こんにちは", []string{"こ", "ん", "に", "ち", "は"}},
+		{"Café naïve", []string{"café", "naïve"}},
+		{"...!?", nil},
+	}
+	for _, tt := range tests {
+		if got := werTokens(tt.in); !slices.Equal(got, tt.want) {
+			t.Errorf("werTokens(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWordErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		text       string
+		transcript string
+		errors     int
+		words      int
+	}{
+		{"exact", "Hello world", "hello world", 0, 2},
+		{"case and punctuation", "Hello, world!", "HELLO WORLD", 0, 2},
+		{"substitution", "the quick fox", "the quick box", 1, 3},
+		{"deletion", "the quick brown fox", "the brown fox", 1, 4},
+		{"insertion", "the fox", "the red fox", 1, 2},
+		{"empty transcript", "one two three", "", 3, 3},
+		{"empty text", "", "anything", 0, 0},
+		{"cjk per character", "你好世界", "你好，世间", 1, 4},
+		{"cjk missing character", "今天天气很好", "今天天气好", 1, 6},
+		{"mixed scripts", "用 Go 写", "用go写", 0, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs, words := wordErrors(tt.text, tt.transcript)
+			if errs != tt.errors || words != tt.words {
+				t.Errorf("wordErrors(%q, %q) = %d/%d, want %d/%d", tt.text, tt.transcript, errs, words, tt.errors, tt.words)
+			}
+		})
+	}
+}
+
+// mumbler is a transcribing backend that never delivers a transcript.
+type mumbler struct{ calls int }
+
+func (*mumbler) requiresAPIKey() bool    { return false }
+func (*mumbler) transcribes(string) bool { return true }
+
+func (m *mumbler) synthesize(ctx context.Context, req synthRequest, emit func([]byte) error) error {
+	m.calls++
+	return emit(make([]byte, 480))
+}
+
+func TestSpeakCheckedMissingTranscript(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold float64
+		wantErr   bool
+		wantCalls int
+	}{
+		{"measured only", 0, false, 1},
+		{"threshold", 0.5, true, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mumbler{}
+			saved, threshold, retries := activeBackend, fidelityThreshold, fidelityRetries
+			t.Cleanup(func() { activeBackend, fidelityThreshold, fidelityRetries = saved, threshold, retries })
+			activeBackend, fidelityThreshold, fidelityRetries = m, tt.threshold, 1
+
+			report := &fidelityReport{}
+			emitted := 0
+			_, err := speakChecked(context.Background(), synthRequest{Text: "hello world", fidelity: report}, func(pcm []byte) error {
+				emitted += len(pcm)
+				return nil
+			})
+			var fidErr *fidelityError
+			if tt.wantErr {
+				if !errors.As(err, &fidErr) || !fidErr.missing {
+					t.Fatalf("err = %v, want a missing transcript", err)
+				}
+				if emitted != 0 {
+					t.Errorf("%d bytes of unchecked audio emitted", emitted)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if m.calls != tt.wantCalls {
+				t.Errorf("backend called %d times, want %d", m.calls, tt.wantCalls)
+			}
+			if report.unverified != 1 {
+				t.Errorf("unverified = %d, want 1", report.unverified)
+			}
+			w := httptest.NewRecorder()
+			reportFidelity(w, httptest.NewRequest("POST", "/tts", nil), report)
+			if got := w.Header().Get("X-Transcript-WER"); got != "unverified" {
+				t.Errorf("X-Transcript-WER = %q, want unverified", got)
+			}
+		})
+	}
+}
+
+func TestBackendTranscribes(t *testing.T) {
+	saved := activeBackend
+	t.Cleanup(func() { activeBackend = saved })
+	tests := []struct {
+		backend synthesizer
+		model   string
+		want    bool
+	}{
+		{geminiSynthesizer{}, "models/gemini-live-2.5-flash-preview", true},
+		{geminiSynthesizer{}, "models/gemini-2.5-flash-preview-tts", false},
+		{generateSynthesizer{}, "models/gemini-2.5-flash-preview-tts", false},
+		{fakeSynthesizer{}, "", true},
+	}
+	for _, tt := range tests {
+		activeBackend = tt.backend
+		if got := backendTranscribes(tt.model); got != tt.want {
+			t.Errorf("%T transcribes %s = %v, want %v", tt.backend, tt.model, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package voxlattice
+
+import (
+	"context"
+	"sync"
+)
+
+// Concurrent identical /tts requests share one upstream synthesis
+var inflight = &flightGroup{}
+
+// flightGroup collapses concurrent calls with the same key into one, in the
+// spirit of x/sync/singleflight. Unlike singleflight, the shared call runs
+// on its own context that is cancelled only once every caller has gone, so
+// one client disconnecting does not fail the others.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done    chan struct{}
+	val     []byte
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// Do runs fn once for all concurrent callers using key and returns its
+// result to each of them. shared reports whether the result was produced for
+// an earlier caller. If ctx ends first, Do returns ctx.Err() and the call
+// keeps running for the remaining callers.
+func (g *flightGroup) Do(ctx context.Context, key string, fn func(ctx context.Context) ([]byte, error)) (val []byte, shared bool, err error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*flightCall{}
+	}
+	call, shared := g.calls[key]
+	if shared {
+		call.waiters++
+	} else {
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &flightCall{done: make(chan struct{}), waiters: 1, cancel: cancel}
+		g.calls[key] = call
+		go g.run(key, call, callCtx, fn)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.val, shared, call.err
+	case <-ctx.Done():
+		g.leave(call)
+		return nil, shared, ctx.Err()
+	}
+}
+
+func (g *flightGroup) run(key string, call *flightCall, ctx context.Context, fn func(ctx context.Context) ([]byte, error)) {
+	call.val, call.err = fn(ctx)
+	g.mu.Lock()
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+	g.mu.Unlock()
+	call.cancel()
+	close(call.done)
+}
+
+// leave drops a caller and cancels the shared call when nobody is left.
+// The call is also forgotten so a later request starts afresh instead of
+// joining a cancelled one.
+func (g *flightGroup) leave(call *flightCall) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	call.waiters--
+	if call.waiters > 0 {
+		return
+	}
+	for key, c := range g.calls {
+		if c == call {
+			delete(g.calls, key)
+			break
+		}
+	}
+	call.cancel()
+}
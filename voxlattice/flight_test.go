@@ -0,0 +1,138 @@
+package voxlattice
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFlightGroupShares(t *testing.T) {
+	var g flightGroup
+	release := make(chan struct{})
+	calls := 0
+	fn := func(ctx context.Context) ([]byte, error) {
+		calls++
+		<-release
+		return []byte("audio"), nil
+	}
+
+	type result struct {
+		val    []byte
+		shared bool
+		err    error
+	}
+	results := make(chan result, 2)
+	go func() {
+		val, shared, err := g.Do(context.Background(), "k", fn)
+		results <- result{val, shared, err}
+	}()
+	waitForWaiters(t, &g, "k", 1)
+	go func() {
+		val, shared, err := g.Do(context.Background(), "k", fn)
+		results <- result{val, shared, err}
+	}()
+	waitForWaiters(t, &g, "k", 2)
+	close(release)
+
+	shared := 0
+	for range 2 {
+		r := <-results
+		if r.err != nil || string(r.val) != "audio" {
+			t.Errorf("Do = %q, %v, want audio", r.val, r.err)
+		}
+		if r.shared {
+			shared++
+		}
+	}
+	if calls != 1 || shared != 1 {
+		t.Errorf("fn ran %d times with %d shared results, want 1 and 1", calls, shared)
+	}
+}
+
+func TestFlightGroupLeaderCancel(t *testing.T) {
+	var g flightGroup
+	release := make(chan struct{})
+	fnErr := make(chan error, 1)
+	fn := func(ctx context.Context) ([]byte, error) {
+		<-release
+		fnErr <- ctx.Err()
+		return []byte("audio"), nil
+	}
+
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error, 1)
+	go func() {
+		_, _, err := g.Do(leaderCtx, "k", fn)
+		leader <- err
+	}()
+	waitForWaiters(t, &g, "k", 1)
+
+	waiter := make(chan []byte, 1)
+	go func() {
+		val, _, err := g.Do(context.Background(), "k", fn)
+		if err != nil {
+			t.Errorf("waiter: %v", err)
+		}
+		waiter <- val
+	}()
+	waitForWaiters(t, &g, "k", 2)
+
+	cancel()
+	if err := <-leader; !errors.Is(err, context.Canceled) {
+		t.Errorf("leader got %v, want context.Canceled", err)
+	}
+	close(release)
+	if err := <-fnErr; err != nil {
+		t.Errorf("shared call saw %v after the leader left", err)
+	}
+	if val := <-waiter; string(val) != "audio" {
+		t.Errorf("waiter got %q, want audio", val)
+	}
+}
+
+func TestFlightGroupLastCallerCancels(t *testing.T) {
+	var g flightGroup
+	ctx, cancel := context.WithCancel(context.Background())
+	fnErr := make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.Do(ctx, "k", func(ctx context.Context) ([]byte, error) {
+			<-ctx.Done()
+			fnErr <- ctx.Err()
+			return nil, ctx.Err()
+		})
+	}()
+	waitForWaiters(t, &g, "k", 1)
+	cancel()
+	<-done
+	select {
+	case err := <-fnErr:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("shared call ended with %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("shared call still running after its only caller left")
+	}
+}
+
+// waitForWaiters blocks until the call for key has n callers.
+func waitForWaiters(t *testing.T, g *flightGroup, key string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		g.mu.Lock()
+		call := g.calls[key]
+		got := 0
+		if call != nil {
+			got = call.waiters
+		}
+		g.mu.Unlock()
+		if got == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("call %q never reached %d waiters", key, n)
+}
@@ -0,0 +1,76 @@
+package voxlattice
+
+import "encoding/binary"
+
+// G.711 companding, following the reference implementation in Sun's g711.c.
+
+var (
+	muLawSegEnd = [8]int{0x3F, 0x7F, 0xFF, 0x1FF, 0x3FF, 0x7FF, 0xFFF, 0x1FFF}
+	aLawSegEnd  = [8]int{0x1F, 0x3F, 0x7F, 0xFF, 0x1FF, 0x3FF, 0x7FF, 0xFFF}
+)
+
+func g711Segment(v int, ends *[8]int) int {
+	for i, end := range ends {
+		if v <= end {
+			return i
+		}
+	}
+	return len(ends)
+}
+
+func linearToMulaw(sample int16) byte {
+	const clip = 8159
+	const bias = 0x84 >> 2
+
+	v := int(sample) >> 2
+	mask := 0xFF
+	if v < 0 {
+		v = -v
+		mask = 0x7F
+	}
+	if v > clip {
+		v = clip
+	}
+	v += bias
+	seg := g711Segment(v, &muLawSegEnd)
+	if seg >= 8 {
+		return byte(0x7F ^ mask)
+	}
+	return byte(((seg << 4) | ((v >> (seg + 1)) & 0x0F)) ^ mask)
+}
+
+func linearToAlaw(sample int16) byte {
+	v := int(sample) >> 3
+	mask := 0xD5
+	if v < 0 {
+		mask = 0x55
+		v = -v - 1
+	}
+	seg := g711Segment(v, &aLawSegEnd)
+	if seg >= 8 {
+		return byte(0x7F ^ mask)
+	}
+	aval := seg << 4
+	if seg < 2 {
+		aval |= (v >> 1) & 0x0F
+	} else {
+		aval |= (v >> seg) & 0x0F
+	}
+	return byte(aval ^ mask)
+}
+
+func pcmToMulaw(pcm []byte) []byte {
+	out := make([]byte, len(pcm)/2)
+	for i := range out {
+		out[i] = linearToMulaw(int16(binary.LittleEndian.Uint16(pcm[i*2:])))
+	}
+	return out
+}
+
+func pcmToAlaw(pcm []byte) []byte {
+	out := make([]byte, len(pcm)/2)
+	for i := range out {
+		out[i] = linearToAlaw(int16(binary.LittleEndian.Uint16(pcm[i*2:])))
+	}
+	return out
+}
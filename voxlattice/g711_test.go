@@ -0,0 +1,108 @@
+package voxlattice
+
+import "testing"
+
+// mulawToLinear and alawToLinear are the decoders of the reference g711.c.
+func mulawToLinear(u byte) int16 {
+	u = ^u
+	t := (int(u&0x0F) << 3) + 0x84
+	t <<= (u & 0x70) >> 4
+	if u&0x80 != 0 {
+		return int16(0x84 - t)
+	}
+	return int16(t - 0x84)
+}
+
+func alawToLinear(a byte) int16 {
+	a ^= 0x55
+	t := int(a&0x0F) << 4
+	switch seg := (a & 0x70) >> 4; seg {
+	case 0:
+		t += 8
+	case 1:
+		t += 0x108
+	default:
+		t += 0x108
+		t <<= seg - 1
+	}
+	if a&0x80 != 0 {
+		return int16(t)
+	}
+	return int16(-t)
+}
+
+func TestLinearToMulaw(t *testing.T) {
+	// Codewords of the reference encoder
+	tests := []struct {
+		sample int16
+		want   byte
+	}{
+		{0, 0xFF},
+		{-1, 0x7E},
+		{32767, 0x80},
+		{-32768, 0x00},
+		{32124, 0x80},
+		{-32124, 0x00},
+		{1000, 0xCE},
+		{-1000, 0x4E},
+		{100, 0xF2},
+		{8000, 0xA0},
+	}
+	for _, tt := range tests {
+		if got := linearToMulaw(tt.sample); got != tt.want {
+			t.Errorf("linearToMulaw(%d) = %#02x, want %#02x", tt.sample, got, tt.want)
+		}
+	}
+	if got := mulawToLinear(0x00); got != -32124 {
+		t.Fatalf("reference decoder gives %d for 0x00, want -32124", got)
+	}
+	// Every codeword decodes to a value that encodes back to it; 0x7F is
+	// negative zero and comes back as 0xFF.
+	for c := 0; c < 256; c++ {
+		if c == 0x7F {
+			continue
+		}
+		if got := linearToMulaw(mulawToLinear(byte(c))); got != byte(c) {
+			t.Errorf("codeword %#02x decodes to %d, which encodes to %#02x", c, mulawToLinear(byte(c)), got)
+		}
+	}
+}
+
+func TestLinearToAlaw(t *testing.T) {
+	tests := []struct {
+		sample int16
+		want   byte
+	}{
+		{0, 0xD5},
+		{-1, 0x55},
+		{32767, 0xAA},
+		{-32768, 0x2A},
+		{1000, 0xFA},
+		{-1000, 0x7A},
+		{100, 0xD3},
+		{8000, 0x8A},
+	}
+	for _, tt := range tests {
+		if got := linearToAlaw(tt.sample); got != tt.want {
+			t.Errorf("linearToAlaw(%d) = %#02x, want %#02x", tt.sample, got, tt.want)
+		}
+	}
+	if got := alawToLinear(0x00); got != -5504 {
+		t.Fatalf("reference decoder gives %d for 0x00, want -5504", got)
+	}
+	for c := 0; c < 256; c++ {
+		if got := linearToAlaw(alawToLinear(byte(c))); got != byte(c) {
+			t.Errorf("codeword %#02x decodes to %d, which encodes to %#02x", c, alawToLinear(byte(c)), got)
+		}
+	}
+}
+
+func TestPCMToG711(t *testing.T) {
+	pcm := []byte{0x00, 0x00, 0xFF, 0x7F, 0x00, 0x80, 0xFF} // trailing odd byte ignored
+	if got := pcmToMulaw(pcm); string(got) != "\xFF\x80\x00" {
+		t.Errorf("pcmToMulaw = %x, want ff8000", got)
+	}
+	if got := pcmToAlaw(pcm); string(got) != "\xD5\xAA\x2A" {
+		t.Errorf("pcmToAlaw = %x, want d5aa2a", got)
+	}
+}
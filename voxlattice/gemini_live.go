@@ -0,0 +1,199 @@
+package voxlattice
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/genai"
+)
+
+// liveSynthesizer speaks through the Gemini Live API, asking the model to
+// repeat the user's text verbatim.
+type liveSynthesizer struct{}
+
+func (liveSynthesizer) requiresAPIKey() bool { return true }
+
+func (liveSynthesizer) transcribes(string) bool { return true }
+
+// synthesize runs one Live session for req and hands every PCM chunk to emit
+// as soon as it is received. An error returned by emit aborts the session.
+func (liveSynthesizer) synthesize(ctx context.Context, req synthRequest, emit func([]byte) error) error {
+	session, err := liveConnect(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer closeLive(session)
+	// Transcriptions may trail generationComplete, so wait for the turn to end.
+	return liveSpeak(ctx, session, req.Text, req.onTranscript != nil, req.onTranscript, emit)
+}
+
+// openSession keeps one Live session open so consecutive texts are spoken
+// without a new handshake each time.
+func (liveSynthesizer) openSession(ctx context.Context, req synthRequest) (speechSession, error) {
+	session, err := liveConnect(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return &liveSession{ctx: ctx, req: req, session: session}, nil
+}
+
+// liveSession speaks each text as a new turn of one Live session. After a
+// failure the session is dropped and the next text reconnects.
+type liveSession struct {
+	ctx     context.Context
+	req     synthRequest
+	session *genai.Session
+}
+
+func (s *liveSession) speak(ctx context.Context, text string, emit func([]byte) error) error {
+	if s.session == nil {
+		session, err := liveConnect(detachedContext(s.ctx, ctx), s.req)
+		if err != nil {
+			return err
+		}
+		s.session = session
+	}
+	if err := liveSpeak(ctx, s.session, text, true, s.req.onTranscript, emit); err != nil {
+		closeLive(s.session)
+		s.session = nil
+		return err
+	}
+	return nil
+}
+
+func (s *liveSession) Close() error {
+	if s.session == nil {
+		return nil
+	}
+	err := closeLive(s.session)
+	s.session = nil
+	return err
+}
+
+// liveConnect opens a Live session configured to read texts aloud with the
+// voice and language of req.
+func liveConnect(ctx context.Context, req synthRequest) (*genai.Session, error) {
+	_, span := startSpan(ctx, "genai.NewClient")
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{
+		APIKey:  req.APIKey,
+		Backend: genai.BackendGeminiAPI,
+	})
+	endSpan(span, err)
+	if err != nil {
+		return nil, &synthError{stage: "client init", err: err}
+	}
+	// Note: genai client typically manages connections itself, no explicit close needed
+
+	systemInstruction := &genai.Content{
+		Parts: []*genai.Part{
+			{Text: "You are a TTS engine. Repeat the user's text verbatim. Do not add, remove, translate, or rephrase. Output audio only."},
+		},
+	}
+
+	cfg := &genai.LiveConnectConfig{
+		ResponseModalities: []genai.Modality{genai.ModalityAudio},
+		Temperature:        genai.Ptr[float32](0),
+		SystemInstruction:  systemInstruction,
+	}
+
+	// Configure voice if specified
+	if req.Voice != "" {
+		cfg.SpeechConfig = &genai.SpeechConfig{
+			VoiceConfig: &genai.VoiceConfig{
+				PrebuiltVoiceConfig: &genai.PrebuiltVoiceConfig{
+					VoiceName: req.Voice,
+				},
+			},
+		}
+	}
+
+	// If language is specified, we can potentially add language-specific instructions
+	if req.Lang != "" {
+		// Add language-specific instruction to the system instruction
+		langInstruction := fmt.Sprintf("Respond in %s language with appropriate pronunciation.", req.Lang)
+		systemInstruction.Parts = append(systemInstruction.Parts, &genai.Part{Text: langInstruction})
+	}
+
+	// Word timings are derived from the transcription of the spoken audio
+	if req.onTranscript != nil {
+		cfg.OutputAudioTranscription = &genai.AudioTranscriptionConfig{}
+	}
+
+	// SSML prosody and emphasis arrive as a delivery instruction
+	if req.Style != "" {
+		styleInstruction := fmt.Sprintf("Read the text %s, still word for word.", req.Style)
+		systemInstruction.Parts = append(systemInstruction.Parts, &genai.Part{Text: styleInstruction})
+	}
+
+	_, span = startSpan(ctx, "Live.Connect", speechAttrs(req)...)
+	session, err := client.Live.Connect(ctx, req.Model, cfg)
+	endSpan(span, err)
+	if err != nil {
+		return nil, &synthError{stage: "live connect", err: err}
+	}
+	liveSessions.Add(1)
+	return session, nil
+}
+
+// closeLive closes a session opened by liveConnect.
+func closeLive(session *genai.Session) error {
+	liveSessions.Add(-1)
+	return session.Close()
+}
+
+// liveSpeak sends text as one turn and emits the audio of the reply until
+// generation completes. A session that is reused must also wait for the
+// turnComplete that follows, or the next turn would read it as its own end.
+// Output transcriptions go to onTranscript when it is set.
+func liveSpeak(ctx context.Context, session *genai.Session, text string, reuse bool, onTranscript func(string), emit func([]byte) error) (err error) {
+	turn := genai.NewContentFromText(text, genai.RoleUser)
+	_, span := startSpan(ctx, "Live.SendClientContent", attribute.Int("tts.text_length", len([]rune(text))))
+	err = session.SendClientContent(genai.LiveClientContentInput{
+		Turns:        []*genai.Content{turn},
+		TurnComplete: genai.Ptr(true),
+	})
+	endSpan(span, err)
+	if err != nil {
+		return &synthError{stage: "clientContent send", err: err}
+	}
+
+	// One span for the whole wait, with an event when audio starts
+	_, span = startSpan(ctx, "Live.Receive")
+	messages, audioBytes := 0, 0
+	defer func() {
+		span.SetAttributes(attribute.Int("live.messages", messages), attribute.Int("tts.audio_bytes", audioBytes))
+		endSpan(span, err)
+	}()
+	for {
+		msg, err := session.Receive()
+		if err != nil {
+			return &synthError{stage: "read", err: err}
+		}
+		messages++
+
+		if msg.ServerContent != nil && msg.ServerContent.ModelTurn != nil {
+			for _, p := range msg.ServerContent.ModelTurn.Parts {
+				if p.InlineData != nil && len(p.InlineData.Data) > 0 {
+					if audioBytes == 0 {
+						span.AddEvent("first audio")
+					}
+					audioBytes += len(p.InlineData.Data)
+					if err := emit(p.InlineData.Data); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		if msg.ServerContent != nil && msg.ServerContent.OutputTranscription != nil && onTranscript != nil {
+			if t := msg.ServerContent.OutputTranscription.Text; t != "" {
+				onTranscript(t)
+			}
+		}
+
+		if msg.ServerContent != nil && (msg.ServerContent.TurnComplete || (msg.ServerContent.GenerationComplete && !reuse)) {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package voxlattice
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/genai"
+)
+
+// generateSynthesizer speaks through generateContent on the dedicated TTS
+// models, which read the prompt aloud instead of answering it.
+type generateSynthesizer struct{}
+
+func (generateSynthesizer) requiresAPIKey() bool { return true }
+
+// synthesize streams the generateContent response for req and hands every
+// PCM chunk to emit as it arrives. An error returned by emit aborts the call.
+func (generateSynthesizer) synthesize(ctx context.Context, req synthRequest, emit func([]byte) error) error {
+	speech := &genai.SpeechConfig{
+		LanguageCode: req.Lang,
+		VoiceConfig:  prebuiltVoice(req.Voice),
+	}
+	prompt := req.Text
+	if req.Style != "" {
+		// The TTS models take delivery directions as a "Say ...:" preamble
+		prompt = "Say " + req.Style + ": " + req.Text
+	}
+	return generateSpeech(ctx, req.APIKey, req.Model, prompt, speech, emit)
+}
+
+// synthesizeDialogue voices a two-speaker script in one call using
+// MultiSpeakerVoiceConfig. The model tells speakers apart by the
+// "Name: line" prefixes in the prompt.
+func (generateSynthesizer) synthesizeDialogue(ctx context.Context, req dialogueRequest, emit func([]byte) error) error {
+	multi := &genai.MultiSpeakerVoiceConfig{}
+	for _, name := range dialogueSpeakers(req.Turns) {
+		multi.SpeakerVoiceConfigs = append(multi.SpeakerVoiceConfigs, &genai.SpeakerVoiceConfig{
+			Speaker:     name,
+			VoiceConfig: prebuiltVoice(req.Voices[name]),
+		})
+	}
+	speech := &genai.SpeechConfig{
+		LanguageCode:            req.Lang,
+		MultiSpeakerVoiceConfig: multi,
+	}
+	return generateSpeech(ctx, req.APIKey, req.Model, dialogueScript(req.Turns), speech, emit)
+}
+
+func prebuiltVoice(voice string) *genai.VoiceConfig {
+	if voice == "" {
+		return nil
+	}
+	return &genai.VoiceConfig{
+		PrebuiltVoiceConfig: &genai.PrebuiltVoiceConfig{
+			VoiceName: voice,
+		},
+	}
+}
+
+// dialogueScript renders turns as the transcript the TTS models expect.
+func dialogueScript(turns []dialogueTurn) string {
+	names := dialogueSpeakers(turns)
+	var b strings.Builder
+	b.WriteString("TTS the following conversation between ")
+	b.WriteString(strings.Join(names, " and "))
+	b.WriteString(":\n")
+	for _, t := range turns {
+		b.WriteString(t.Speaker)
+		b.WriteString(": ")
+		b.WriteString(strings.Join(strings.Fields(t.Text), " "))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func generateSpeech(ctx context.Context, apiKey, model, prompt string, speech *genai.SpeechConfig, emit func([]byte) error) (err error) {
+	_, span := startSpan(ctx, "genai.NewClient")
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{
+		APIKey:  apiKey,
+		Backend: genai.BackendGeminiAPI,
+	})
+	endSpan(span, err)
+	if err != nil {
+		return &synthError{stage: "client init", err: err}
+	}
+
+	cfg := &genai.GenerateContentConfig{
+		ResponseModalities: []string{string(genai.ModalityAudio)},
+		Temperature:        genai.Ptr[float32](0),
+		SpeechConfig:       speech,
+	}
+
+	_, span = startSpan(ctx, "Models.GenerateContentStream",
+		attribute.String("tts.model", strings.TrimPrefix(model, "models/")),
+		attribute.Int("tts.text_length", len([]rune(prompt))),
+	)
+	audioBytes := 0
+	defer func() {
+		span.SetAttributes(attribute.Int("tts.audio_bytes", audioBytes))
+		endSpan(span, err)
+	}()
+	contents := []*genai.Content{genai.NewContentFromText(prompt, genai.RoleUser)}
+	for resp, err := range client.Models.GenerateContentStream(ctx, model, contents, cfg) {
+		if err != nil {
+			return &synthError{stage: "generate", err: err}
+		}
+		for _, cand := range resp.Candidates {
+			if cand.Content == nil {
+				continue
+			}
+			for _, p := range cand.Content.Parts {
+				if p.InlineData != nil && len(p.InlineData.Data) > 0 {
+					if audioBytes == 0 {
+						span.AddEvent("first audio")
+					}
+					audioBytes += len(p.InlineData.Data)
+					if err := emit(p.InlineData.Data); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -14,7 +14,9 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 	response := healthResp{
 		Status:  "healthy",
 		Model:   getModelName(),
-		Voices:  supportedVoices,
+		Backend: activeBackendName,
+		Voices:  currentVoices(),
+		Formats: availableFormats(),
 		Message: "Voxlattice TTS service ready with custom voice support",
 	}
 
@@ -0,0 +1,839 @@
+package voxlattice
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	maxJobItems         = 1000
+	defaultJobWorkers   = 2
+	defaultJobRetention = 7 * 24 * time.Hour
+	jobFileExt          = ".json"
+)
+
+// Job and item states
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+	jobCanceled  = "canceled"
+)
+
+// File extensions of job results inside a zip, by format name
+var jobResultExts = map[string]string{
+	"wav":       "wav",
+	"mulaw_wav": "wav",
+	"alaw_wav":  "wav",
+	"pcm_s16le": "pcm",
+	"mulaw":     "ulaw",
+	"alaw":      "alaw",
+	"mp3":       "mp3",
+	"opus":      "ogg",
+	"marks":     "jsonl",
+	"srt":       "srt",
+	"vtt":       "vtt",
+}
+
+// Background synthesis jobs (nil until Run starts them)
+var jobQueue *jobManager
+
+// jobRecord is a job as persisted in <config>/jobs/<id>.json. Results are
+// stored next to it in <config>/jobs/<id>/<index>.
+type jobRecord struct {
+	ID       string     `json:"id"`
+	Status   string     `json:"status"`
+	Created  time.Time  `json:"created_at"`
+	Started  *time.Time `json:"started_at,omitempty"`
+	Finished *time.Time `json:"finished_at,omitempty"`
+	// The caller's Gemini key, cleared once the job ends; empty means
+	// GEMINI_API_KEY at run time
+	APIKey string `json:"api_key,omitempty"`
+	// Name of the client key that submitted the job
+	Client string `json:"client,omitempty"`
+	// Who may read or cancel the job, see jobOwner
+	Owner string `json:"owner,omitempty"`
+	// Whose quotas the characters were charged to, for refunds
+	UsageID string          `json:"usage_id,omitempty"`
+	Items   []jobItemRecord `json:"items"`
+	// Where to POST the outcome, this server's address for the links in
+	// it, and how delivery went (pending, delivered, dead)
+	CallbackURL string `json:"callback_url,omitempty"`
+	BaseURL     string `json:"base_url,omitempty"`
+	Callback    string `json:"callback_state,omitempty"`
+}
+
+type jobItemRecord struct {
+	Request     ttsReq `json:"request"` // normalized, with the format resolved
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Bytes       int    `json:"bytes,omitempty"`
+	// Characters charged to the submitter's quota for this item
+	Chars int64 `json:"chars,omitempty"`
+}
+
+func (j *jobRecord) finished() bool {
+	return j.Status == jobSucceeded || j.Status == jobFailed || j.Status == jobCanceled
+}
+
+func (j *jobRecord) response() jobResp {
+	resp := jobResp{
+		ID:         j.ID,
+		Status:     j.Status,
+		CreatedAt:  j.Created,
+		StartedAt:  j.Started,
+		FinishedAt: j.Finished,
+		Total:      len(j.Items),
+		Items:      make([]jobItemResp, len(j.Items)),
+	}
+	for i, item := range j.Items {
+		switch item.Status {
+		case jobSucceeded:
+			resp.Completed++
+		case jobFailed:
+			resp.Failed++
+		}
+		resp.Items[i] = jobItemResp{
+			Index:       i,
+			Status:      item.Status,
+			Error:       item.Error,
+			ContentType: item.ContentType,
+			Bytes:       item.Bytes,
+		}
+	}
+	if resp.Total > 0 {
+		resp.Progress = float64(resp.Completed+resp.Failed) / float64(resp.Total)
+	}
+	return resp
+}
+
+// jobManager schedules job items onto a fixed number of workers, oldest job
+// first, and keeps every job on disk so queued work survives a restart.
+type jobManager struct {
+	dir string
+
+	mu    sync.Mutex
+	cond  *sync.Cond
+	jobs  map[string]*jobRecord
+	order []string // job IDs by submission time
+	runs  map[string]jobRun
+}
+
+// jobRun is the context of a job that has started.
+type jobRun struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// newJobManager loads the jobs in dir and puts anything unfinished back in
+// the queue; items that were running when the process stopped start over.
+func newJobManager(dir string) (*jobManager, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	m := &jobManager{
+		dir:  dir,
+		jobs: map[string]*jobRecord{},
+		runs: map[string]jobRun{},
+	}
+	m.cond = sync.NewCond(&m.mu)
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasSuffix(name, jobFileExt) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			appLog.Warnf("job %s unreadable: %v", name, err)
+			continue
+		}
+		var j jobRecord
+		if err := json.Unmarshal(data, &j); err != nil || j.ID+jobFileExt != name {
+			appLog.Warnf("job %s is corrupt, skipping", name)
+			continue
+		}
+		for i := range j.Items {
+			if j.Items[i].Status != jobRunning {
+				continue
+			}
+			if j.finished() {
+				j.Items[i].Status = jobCanceled
+			} else {
+				j.Items[i].Status = jobQueued
+			}
+		}
+		if j.finished() && j.APIKey != "" {
+			// Ended while a key was still kept on disk
+			j.APIKey = ""
+			m.saveLocked(&j)
+		}
+		m.jobs[j.ID] = &j
+		m.order = append(m.order, j.ID)
+	}
+	sort.Slice(m.order, func(a, b int) bool {
+		return m.jobs[m.order[a]].Created.Before(m.jobs[m.order[b]].Created)
+	})
+	return m, nil
+}
+
+// start launches the workers and resumes callbacks cut off by a restart.
+// Finished jobs are removed with their results once they are older than
+// retention; zero keeps them until deleted.
+func (m *jobManager) start(workers int, retention time.Duration) {
+	for i := 0; i < workers; i++ {
+		go m.work()
+	}
+	if retention > 0 {
+		go m.expire(retention)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range m.order {
+		if j := m.jobs[id]; j.finished() && j.Callback == callbackPending {
+			m.notifyLocked(j)
+		}
+	}
+}
+
+func (m *jobManager) work() {
+	for {
+		ctx, id, index := m.next()
+		m.runItem(ctx, id, index)
+	}
+}
+
+// next blocks until an item is queued and marks it running.
+func (m *jobManager) next() (context.Context, string, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for {
+		for _, id := range m.order {
+			j := m.jobs[id]
+			if j.finished() {
+				continue
+			}
+			for i := range j.Items {
+				if j.Items[i].Status != jobQueued {
+					continue
+				}
+				j.Items[i].Status = jobRunning
+				if j.Status == jobQueued {
+					now := time.Now().UTC()
+					j.Status, j.Started = jobRunning, &now
+				}
+				m.saveLocked(j)
+				return m.contextLocked(id), id, i
+			}
+		}
+		m.cond.Wait()
+	}
+}
+
+// contextLocked returns the context shared by the items of job id, which
+// DELETE cancels.
+func (m *jobManager) contextLocked(id string) context.Context {
+	run, ok := m.runs[id]
+	if !ok {
+		run.ctx, run.cancel = context.WithCancel(context.Background())
+		m.runs[id] = run
+	}
+	return run.ctx
+}
+
+func (m *jobManager) runItem(ctx context.Context, id string, index int) {
+	m.mu.Lock()
+	j := m.jobs[id]
+	if j == nil {
+		m.mu.Unlock()
+		return
+	}
+	req, apiKey := j.Items[index].Request, j.APIKey
+	m.mu.Unlock()
+
+	ctx, span := tracer.Start(ctx, "job.item", trace.WithAttributes(
+		attribute.String("job.id", id),
+		attribute.Int("job.item", index),
+	))
+	body, contentType, cached, err := synthesizeJobItem(ctx, req, apiKey)
+	if err == nil {
+		err = writeFileAtomic(m.resultPath(id, index), body)
+	}
+	endSpan(span, err)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.jobs[id] != j {
+		return // deleted meanwhile
+	}
+	item := &j.Items[index]
+	switch {
+	case j.Status == jobCanceled:
+		item.Status = jobCanceled
+		refundJobItem(j, index)
+	case err != nil:
+		refundJobItem(j, index)
+		countSynthError("/jobs", err)
+		item.Status, item.Error = jobFailed, err.Error()
+		appLog.Warnf("job %s item %d failed: %v", id, index, err)
+	default:
+		item.Status, item.ContentType, item.Bytes = jobSucceeded, contentType, len(body)
+		if cached {
+			refundJobItem(j, index) // no upstream call, so no characters
+		}
+	}
+	m.settleLocked(j)
+	m.saveLocked(j)
+}
+
+// refundJobItem gives back the characters charged for item index of j,
+// which will never be spoken.
+func refundJobItem(j *jobRecord, index int) {
+	item := &j.Items[index]
+	if usage != nil && j.UsageID != "" {
+		usage.refund(j.UsageID, item.Chars, j.Created)
+	}
+	item.Chars = 0
+}
+
+// synthesizeJobItem renders one item exactly as /tts would, cache included;
+// cached tells whether it was served from the cache.
+func synthesizeJobItem(ctx context.Context, req ttsReq, apiKey string) (body []byte, contentType string, cached bool, err error) {
+	p, err := prepareTTS(req, "")
+	if err != nil {
+		return nil, "", false, err
+	}
+	if audioCache != nil {
+		if body, ok := audioCache.Get(p.key); ok {
+			return body, p.contentType(), true, nil
+		}
+	}
+	if apiKey == "" && activeBackend.requiresAPIKey() {
+		var ok bool
+		if apiKey, ok = serverAPIKey(); !ok {
+			return nil, "", false, errors.New("missing api key")
+		}
+	}
+	plan := p.plan()
+	body, _, err = p.render(ctx, p.synthRequest(apiKey), plan, planTimeout(plan))
+	return body, p.contentType(), false, err
+}
+
+// settleLocked finishes j once none of its items is left to run.
+func (m *jobManager) settleLocked(j *jobRecord) {
+	if j.finished() {
+		return
+	}
+	failed := false
+	for _, item := range j.Items {
+		switch item.Status {
+		case jobQueued, jobRunning:
+			return
+		case jobFailed:
+			failed = true
+		}
+	}
+	j.Status = jobSucceeded
+	if failed {
+		j.Status = jobFailed
+	}
+	now := time.Now().UTC()
+	j.Finished = &now
+	j.APIKey = ""
+	m.releaseLocked(j.ID)
+	m.notifyLocked(j)
+}
+
+// expire removes finished jobs older than retention, checking as often as
+// the retention allows but at least hourly. Jobs whose callback is still
+// being delivered are kept until it is done.
+func (m *jobManager) expire(retention time.Duration) {
+	for range time.Tick(min(retention, time.Hour)) {
+		cutoff := time.Now().Add(-retention)
+		m.mu.Lock()
+		for _, id := range slices.Clone(m.order) {
+			j := m.jobs[id]
+			if j.finished() && j.Finished != nil && j.Finished.Before(cutoff) && j.Callback != callbackPending {
+				m.removeLocked(id)
+				appLog.Infof("job %s expired", id)
+			}
+		}
+		m.mu.Unlock()
+	}
+}
+
+func (m *jobManager) releaseLocked(id string) {
+	if run, ok := m.runs[id]; ok {
+		run.cancel()
+		delete(m.runs, id)
+	}
+}
+
+// submit queues a job of items described by template, which carries who
+// submitted it and where to report; the rest is filled in here.
+func (m *jobManager) submit(items []jobItemRecord, template jobRecord) (*jobRecord, error) {
+	j := &template
+	j.ID = newJobID()
+	j.Status = jobQueued
+	j.Created = time.Now().UTC()
+	j.Items = items
+	for i := range j.Items {
+		j.Items[i].Status = jobQueued
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.saveLocked(j); err != nil {
+		return nil, err
+	}
+	m.jobs[j.ID] = j
+	m.order = append(m.order, j.ID)
+	m.cond.Broadcast()
+	return j, nil
+}
+
+// get returns a snapshot of job id.
+func (m *jobManager) get(id string) (jobRecord, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return jobRecord{}, false
+	}
+	snapshot := *j
+	snapshot.Items = append([]jobItemRecord(nil), j.Items...)
+	return snapshot, true
+}
+
+// cancel stops an unfinished job, keeping it so its state can still be
+// read. A finished job is removed together with its results instead.
+func (m *jobManager) cancel(id string) (jobRecord, bool, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return jobRecord{}, false, false
+	}
+	if j.finished() {
+		m.removeLocked(id)
+		return *j, true, true
+	}
+
+	for i := range j.Items {
+		if j.Items[i].Status == jobQueued {
+			j.Items[i].Status = jobCanceled
+			refundJobItem(j, i)
+		}
+	}
+	j.Status = jobCanceled
+	now := time.Now().UTC()
+	j.Finished = &now
+	j.APIKey = ""
+	m.releaseLocked(id)
+	m.saveLocked(j)
+	return *j, true, false
+}
+
+// removeLocked forgets job id and deletes its record and results.
+func (m *jobManager) removeLocked(id string) {
+	delete(m.jobs, id)
+	for i, v := range m.order {
+		if v == id {
+			m.order = append(m.order[:i], m.order[i+1:]...)
+			break
+		}
+	}
+	if err := os.Remove(m.recordPath(id)); err != nil && !os.IsNotExist(err) {
+		appLog.Warnf("job %s remove failed: %v", id, err)
+	}
+	if err := os.RemoveAll(filepath.Join(m.dir, id)); err != nil {
+		appLog.Warnf("job %s remove failed: %v", id, err)
+	}
+}
+
+func (m *jobManager) recordPath(id string) string {
+	return filepath.Join(m.dir, id+jobFileExt)
+}
+
+func (m *jobManager) resultPath(id string, index int) string {
+	return filepath.Join(m.dir, id, fmt.Sprintf("%d", index))
+}
+
+// saveLocked persists j; a failure is logged and returned, the job goes on.
+func (m *jobManager) saveLocked(j *jobRecord) error {
+	data, err := json.Marshal(j)
+	if err == nil {
+		err = writeFileAtomic(m.recordPath(j.ID), data)
+	}
+	if err != nil {
+		appLog.Warnf("job %s save failed: %v", j.ID, err)
+	}
+	return err
+}
+
+// writeFileAtomic writes data to a temp file beside path and renames it into
+// place, so readers and restarts never see a partial file.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func newJobID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// resolveJobsDir places the job store inside the config directory.
+func resolveJobsDir(configDir string) string {
+	dir := strings.TrimSpace(configDir)
+	if dir == "" || dir == "." {
+		return "jobs"
+	}
+	return filepath.Join(dir, "jobs")
+}
+
+// parseJobItems accepts {"items": [...], "callback_url": ...} or a single
+// /tts request object, and returns the items and the callback URL.
+func parseJobItems(body []byte) ([]ttsReq, string, error) {
+	var envelope struct {
+		Items       []json.RawMessage `json:"items"`
+		CallbackURL string            `json:"callback_url"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, "", errors.New("invalid json")
+	}
+	raws := envelope.Items
+	if raws == nil {
+		req, err := parseTTSBody(body)
+		if err != nil {
+			return nil, "", err
+		}
+		callbackURL := req.CallbackURL
+		req.CallbackURL = ""
+		return []ttsReq{req}, callbackURL, nil
+	}
+	if len(raws) == 0 {
+		return nil, "", errors.New("items must not be empty")
+	}
+	if len(raws) > maxJobItems {
+		return nil, "", fmt.Errorf("too many items: %d > %d", len(raws), maxJobItems)
+	}
+	items := make([]ttsReq, len(raws))
+	for i, raw := range raws {
+		req, err := parseTTSBody(raw)
+		if err != nil {
+			return nil, "", fmt.Errorf("item %d: %v", i, err)
+		}
+		if req.CallbackURL != "" {
+			return nil, "", fmt.Errorf("item %d: callback_url belongs on the job, not on its items", i)
+		}
+		items[i] = req
+	}
+	return items, envelope.CallbackURL, nil
+}
+
+// prepareJobItem validates req now and returns it in the form stored with
+// the job, so running it later needs no HTTP request.
+func prepareJobItem(req ttsReq, accept string) (ttsReq, error) {
+	if req.TimingFormat != "" && timingFormats[strings.ToLower(strings.TrimSpace(req.Format))].name == "" {
+		return req, errors.New("timing_format is not supported in jobs, add an item with format marks, srt or vtt instead")
+	}
+	p, err := prepareTTS(req, accept)
+	if err != nil {
+		return req, err
+	}
+	stored := p.req
+	if p.timingOnly {
+		stored.Format, stored.TimingFormat = p.timing.name, ""
+	} else {
+		stored.Format = p.format.name
+	}
+	return stored, nil
+}
+
+// jobsHandler serves POST /jobs.
+func jobsHandler(w http.ResponseWriter, r *http.Request) {
+	// Allow CORS for web access
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, X-Gemini-Api-Key")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	items, callbackURL, err := parseJobItems(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	accept := r.Header.Get("Accept")
+	for i := range items {
+		if items[i], err = prepareJobItem(items[i], accept); err != nil {
+			msg := err.Error()
+			if len(items) > 1 {
+				msg = fmt.Sprintf("item %d: %s", i, msg)
+			}
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+	}
+	submitJob(w, r, items, callbackURL)
+}
+
+// submitJob queues validated items and answers 202 with the new job.
+func submitJob(w http.ResponseWriter, r *http.Request, items []ttsReq, callbackURL string) {
+	if callbackURL != "" {
+		if err := validateCallbackURL(callbackURL); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	auth, err := resolveAPIKey(r)
+	if err != nil {
+		http.Error(w, err.Error(), authErrorStatus(err))
+		return
+	}
+	var spoken strings.Builder
+	var chars int64
+	usageID := auth.clientID(r)
+	records := make([]jobItemRecord, len(items))
+	for i, item := range items {
+		p, err := prepareTTS(item, "")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := auth.checkAccess(p.model, p.req.Voice); err != nil {
+			http.Error(w, err.Error(), authErrorStatus(err))
+			return
+		}
+		spoken.WriteString(p.text())
+		records[i] = jobItemRecord{Request: item, Chars: int64(utf8.RuneCountInString(p.text()))}
+		chars += records[i].Chars
+	}
+	// A job takes one request and all of its characters up front; it runs
+	// on the job workers, so it holds no concurrency slot.
+	if usage != nil {
+		if _, err := usage.admit(usageID, auth.limits(), chars, false); err != nil {
+			setRetryAfter(w, err)
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+	}
+	noteSpeech(r, "", spoken.String())
+
+	// Only a key of the caller's own is stored; GEMINI_API_KEY is looked
+	// up again when the job runs.
+	template := jobRecord{
+		Owner:       jobOwner(auth),
+		UsageID:     usageID,
+		CallbackURL: callbackURL,
+		BaseURL:     publicBaseURL(),
+	}
+	if auth.own {
+		template.APIKey = auth.apiKey
+	}
+	if auth.client != nil {
+		template.Client = auth.client.Name
+	}
+	j, err := jobQueue.submit(records, template)
+	if err != nil {
+		if usage != nil {
+			usage.refund(usageID, chars, time.Now())
+		}
+		http.Error(w, "job store unavailable", http.StatusInternalServerError)
+		return
+	}
+	reqLog(r).Infof("job %s queued with %d items (client %s)", j.ID, len(j.Items), auth.clientName())
+	w.Header().Set("Location", "/jobs/"+j.ID)
+	writeJobJSON(w, http.StatusAccepted, j.response())
+}
+
+// jobHandler serves GET and DELETE /jobs/{id} and GET /jobs/{id}/result.
+func jobHandler(w http.ResponseWriter, r *http.Request) {
+	// Allow CORS for web access
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, X-Gemini-Api-Key")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	id, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/")
+	auth, err := resolveAPIKey(r)
+	if err != nil {
+		http.Error(w, err.Error(), authErrorStatus(err))
+		return
+	}
+	// Someone else's job is reported as missing, not as forbidden
+	j, ok := jobQueue.get(id)
+	if !ok || j.Owner != jobOwner(auth) {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case sub == "" && r.Method == http.MethodGet:
+		writeJobJSON(w, http.StatusOK, j.response())
+	case sub == "" && r.Method == http.MethodDelete:
+		j, ok, removed := jobQueue.cancel(id)
+		if !ok {
+			http.Error(w, "job not found", http.StatusNotFound)
+			return
+		}
+		if removed {
+			appLog.Infof("job %s removed", id)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		appLog.Infof("job %s canceled", id)
+		writeJobJSON(w, http.StatusOK, j.response())
+	case sub == "result" && r.Method == http.MethodGet:
+		writeJobResult(w, j)
+	case sub == "" || sub == "result":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+// writeJobResult returns the output of a finished job: the file itself for
+// a single item, a zip of every successful item otherwise.
+func writeJobResult(w http.ResponseWriter, j jobRecord) {
+	id := j.ID
+	if j.Status != jobSucceeded && j.Status != jobFailed {
+		http.Error(w, "job is "+j.Status, http.StatusConflict)
+		return
+	}
+	var done []int
+	for i, item := range j.Items {
+		if item.Status == jobSucceeded {
+			done = append(done, i)
+		}
+	}
+	if len(done) == 0 {
+		http.Error(w, "job produced no output", http.StatusConflict)
+		return
+	}
+
+	if len(j.Items) == 1 {
+		body, err := os.ReadFile(jobQueue.resultPath(id, 0))
+		if err != nil {
+			http.Error(w, "job result unavailable", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, jobResultName(j, 0)))
+		writeBody(w, j.Items[0].ContentType, body)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, id))
+	zw := zip.NewWriter(w)
+	for _, i := range done {
+		body, err := os.ReadFile(jobQueue.resultPath(id, i))
+		if err != nil {
+			appLog.Warnf("job %s item %d result unavailable: %v", id, i, err)
+			continue
+		}
+		method := zip.Store // audio does not compress
+		if _, ok := timingFormats[j.Items[i].Request.Format]; ok {
+			method = zip.Deflate
+		}
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     jobResultName(j, i),
+			Method:   method,
+			Modified: *j.Finished,
+		})
+		if err != nil {
+			return
+		}
+		if _, err := fw.Write(body); err != nil {
+			return
+		}
+	}
+	_ = zw.Close()
+}
+
+// jobOwner identifies the caller a job belongs to: the client key by name,
+// or a hash of the caller's own Gemini key. Without either, as with a
+// backend that needs no key, jobs are open to every caller.
+func jobOwner(auth callerAuth) string {
+	switch {
+	case auth.client != nil:
+		return "key:" + auth.client.Name
+	case auth.own:
+		return hashClientKey(auth.apiKey)
+	}
+	return ""
+}
+
+// jobResultName numbers results from 1, padded so they sort in order.
+func jobResultName(j jobRecord, index int) string {
+	width := len(fmt.Sprint(len(j.Items)))
+	ext := jobResultExts[j.Items[index].Request.Format]
+	if ext == "" {
+		ext = "bin"
+	}
+	return fmt.Sprintf("%0*d.%s", width, index+1, ext)
+}
+
+func writeJobJSON(w http.ResponseWriter, status int, resp jobResp) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,226 @@
+package voxlattice
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useJobQueue replaces the job queue with an empty one in a temp dir. With
+// no workers its jobs stay queued.
+func useJobQueue(t *testing.T, workers int) *jobManager {
+	t.Helper()
+	saved := jobQueue
+	t.Cleanup(func() { jobQueue = saved })
+	m, err := newJobManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if workers > 0 {
+		m.start(workers, 0)
+	}
+	jobQueue = m
+	return m
+}
+
+// submitTestJob posts body to /jobs and returns the accepted job.
+func submitTestJob(t *testing.T, body string) jobResp {
+	t.Helper()
+	w := serve(jobsHandler, http.MethodPost, "/jobs", body)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("submit status %d: %s", w.Code, w.Body)
+	}
+	var resp jobResp
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if loc := w.Header().Get("Location"); loc != "/jobs/"+resp.ID {
+		t.Errorf("Location %q, want /jobs/%s", loc, resp.ID)
+	}
+	return resp
+}
+
+// waitForJob polls /jobs/{id} until the job has finished.
+func waitForJob(t *testing.T, id string) jobResp {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w := serve(jobHandler, http.MethodGet, "/jobs/"+id, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", w.Code, w.Body)
+		}
+		var resp jobResp
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != jobQueued && resp.Status != jobRunning {
+			return resp
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job still %s", resp.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestJobs(t *testing.T) {
+	useFakeBackend(t)
+	useJobQueue(t, 2)
+
+	job := submitTestJob(t, `{"items":[{"text":"hello","format":"wav"},{"text":"hi there","format":"srt"},{"text":"bye","format":"pcm_s16le"}]}`)
+	if job.Total != 3 || len(job.Items) != 3 {
+		t.Fatalf("job has %d items, want 3", job.Total)
+	}
+	done := waitForJob(t, job.ID)
+	if done.Status != jobSucceeded || done.Completed != 3 || done.Progress != 1 {
+		t.Fatalf("job ended %s with %d/%d done", done.Status, done.Completed, done.Total)
+	}
+
+	w := serve(jobHandler, http.MethodGet, "/jobs/"+job.ID+"/result", "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("result status %d, Content-Type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	files := map[string]string{}
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+	}
+	if !slices.Equal(names, []string{"1.wav", "2.srt", "3.pcm"}) {
+		t.Fatalf("zip holds %v", names)
+	}
+	if !strings.HasPrefix(files["1.wav"], "RIFF") || len(files["3.pcm"]) != fakePCMBytes("bye") {
+		t.Error("audio results do not match the request")
+	}
+	if !strings.Contains(files["2.srt"], "00:00:00,000 --> ") || !strings.Contains(files["2.srt"], "hi there") {
+		t.Errorf("srt result is %q", files["2.srt"])
+	}
+
+	// Deleting a finished job removes it.
+	if w := serve(jobHandler, http.MethodDelete, "/jobs/"+job.ID, ""); w.Code != http.StatusNoContent {
+		t.Errorf("delete status %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if w := serve(jobHandler, http.MethodGet, "/jobs/"+job.ID, ""); w.Code != http.StatusNotFound {
+		t.Errorf("deleted job status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestJobsSingleItemResult(t *testing.T) {
+	useFakeBackend(t)
+	useJobQueue(t, 1)
+
+	job := submitTestJob(t, `{"text":"hello","format":"pcm_s16le"}`)
+	waitForJob(t, job.ID)
+	w := serve(jobHandler, http.MethodGet, "/jobs/"+job.ID+"/result", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("result status %d: %s", w.Code, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "audio/pcm") {
+		t.Errorf("Content-Type %q, want audio/pcm", ct)
+	}
+	if w.Body.Len() != fakePCMBytes("hello") {
+		t.Errorf("result is %d bytes, want %d", w.Body.Len(), fakePCMBytes("hello"))
+	}
+	if cd := w.Header().Get("Content-Disposition"); !strings.Contains(cd, `filename="1.pcm"`) {
+		t.Errorf("Content-Disposition %q", cd)
+	}
+}
+
+func TestJobsCancelQueued(t *testing.T) {
+	useFakeBackend(t)
+	m := useJobQueue(t, 0) // no workers, so the job stays queued
+
+	job := submitTestJob(t, `{"items":[{"text":"one"},{"text":"two"}]}`)
+	if job.Status != jobQueued {
+		t.Fatalf("new job is %s, want %s", job.Status, jobQueued)
+	}
+	// A restart finds the job still queued.
+	reopened, err := newJobManager(m.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j, ok := reopened.get(job.ID); !ok || j.Status != jobQueued {
+		t.Errorf("reopened store has the job %v as %q", ok, j.Status)
+	}
+
+	w := serve(jobHandler, http.MethodDelete, "/jobs/"+job.ID, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("cancel status %d: %s", w.Code, w.Body)
+	}
+	var resp jobResp
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != jobCanceled || resp.Items[0].Status != jobCanceled {
+		t.Errorf("canceled job is %s with first item %s", resp.Status, resp.Items[0].Status)
+	}
+	if w := serve(jobHandler, http.MethodGet, "/jobs/"+job.ID+"/result", ""); w.Code != http.StatusConflict {
+		t.Errorf("result of a canceled job: status %d, want %d", w.Code, http.StatusConflict)
+	}
+	if w := serve(jobHandler, http.MethodDelete, "/jobs/"+job.ID, ""); w.Code != http.StatusNoContent {
+		t.Errorf("second delete status %d, want %d", w.Code, http.StatusNoContent)
+	}
+}
+
+func TestJobsErrors(t *testing.T) {
+	useFakeBackend(t)
+	useVoices(t, "kore")
+	useJobQueue(t, 0)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+	}{
+		{"method", http.MethodGet, "/jobs", "", http.StatusMethodNotAllowed},
+		{"bad json", http.MethodPost, "/jobs", `{"items":`, http.StatusBadRequest},
+		{"empty items", http.MethodPost, "/jobs", `{"items":[]}`, http.StatusBadRequest},
+		{"invalid item", http.MethodPost, "/jobs", `{"items":[{"text":"hi"},{"text":"hi","voice":"nobody"}]}`, http.StatusBadRequest},
+		{"item callback", http.MethodPost, "/jobs", `{"items":[{"text":"hi","callback_url":"https://example.com/"}]}`, http.StatusBadRequest},
+		{"timing format", http.MethodPost, "/jobs", `{"items":[{"text":"hi","timing_format":"srt"}]}`, http.StatusBadRequest},
+		{"unknown job", http.MethodGet, "/jobs/nope", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := jobsHandler
+			if tt.target != "/jobs" {
+				handler = jobHandler
+			}
+			if w := serve(handler, tt.method, tt.target, tt.body); w.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+
+	job := submitTestJob(t, `{"text":"hi"}`)
+	for _, tt := range []struct {
+		method, target string
+		status         int
+	}{
+		{http.MethodPost, "/jobs/" + job.ID, http.StatusMethodNotAllowed},
+		{http.MethodGet, "/jobs/" + job.ID + "/result", http.StatusConflict},
+		{http.MethodGet, "/jobs/" + job.ID + "/other", http.StatusNotFound},
+	} {
+		if w := serve(jobHandler, tt.method, tt.target, ""); w.Code != tt.status {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.target, w.Code, tt.status)
+		}
+	}
+}
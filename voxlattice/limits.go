@@ -0,0 +1,353 @@
+package voxlattice
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+const usageSaveInterval = 10 * time.Second
+
+// Limits for callers whose client key has none of its own, and for callers
+// without a client key. Set from the --rate-* and --quota-* flags.
+var defaultLimits clientLimits
+
+// usage counts what every client has used (nil until Run opens it).
+var usage *usageTracker
+
+// clientLimits caps one client; 0 means no limit.
+type clientLimits struct {
+	RequestsPerMinute int   `json:"requests_per_minute,omitempty"`
+	Concurrent        int   `json:"concurrent,omitempty"`
+	DailyChars        int64 `json:"daily_chars,omitempty"`
+	MonthlyChars      int64 `json:"monthly_chars,omitempty"`
+}
+
+// clientUsage is one client's request bucket and character counts. Days
+// and months are UTC.
+type clientUsage struct {
+	Tokens     float64   `json:"tokens"`
+	Refilled   time.Time `json:"refilled"`
+	Day        string    `json:"day"` // 2006-01-02
+	DayChars   int64     `json:"day_chars"`
+	Month      string    `json:"month"` // 2006-01
+	MonthChars int64     `json:"month_chars"`
+	active     int       // requests in progress, not persisted
+}
+
+// usageTracker enforces clientLimits and keeps the counters in
+// <config>/usage.json so restarts do not reset quotas.
+type usageTracker struct {
+	path    string
+	mu      sync.Mutex
+	clients map[string]*clientUsage
+	dirty   bool
+}
+
+// limitError is a request refused by a rate limit or quota.
+type limitError struct {
+	message    string
+	retryAfter time.Duration
+}
+
+func (e *limitError) Error() string { return e.message }
+
+func resolveUsagePath(configDir string) string {
+	dir := strings.TrimSpace(configDir)
+	if dir == "" || dir == "." {
+		return "usage.json"
+	}
+	return filepath.Join(dir, "usage.json")
+}
+
+func newUsageTracker(path string) (*usageTracker, error) {
+	t := &usageTracker{path: path, clients: map[string]*clientUsage{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &t.clients); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if t.clients == nil {
+		t.clients = map[string]*clientUsage{}
+	}
+	return t, nil
+}
+
+// start saves the counters in the background whenever they changed.
+func (t *usageTracker) start() {
+	go func() {
+		for range time.Tick(usageSaveInterval) {
+			t.save()
+		}
+	}()
+}
+
+// save writes the counters, first dropping clients that have been idle
+// long enough for nothing about them to matter any more.
+func (t *usageTracker) save() {
+	t.mu.Lock()
+	if !t.dirty {
+		t.mu.Unlock()
+		return
+	}
+	month := time.Now().UTC().Format("2006-01")
+	for id, u := range t.clients {
+		if u.active == 0 && u.Month != month && time.Since(u.Refilled) > time.Minute {
+			delete(t.clients, id)
+		}
+	}
+	data, err := json.Marshal(t.clients)
+	t.dirty = false
+	t.mu.Unlock()
+	if err == nil {
+		err = writeFileAtomic(t.path, data)
+	}
+	if err != nil {
+		appLog.Errorf("usage save failed: %v", err)
+	}
+}
+
+// clientLocked returns the counters of client id with the request bucket
+// refilled and the day and month rolled over to now.
+func (t *usageTracker) clientLocked(id string, lim clientLimits, now time.Time) *clientUsage {
+	u, ok := t.clients[id]
+	if !ok {
+		u = &clientUsage{}
+		t.clients[id] = u
+	}
+	if capacity := float64(lim.RequestsPerMinute); capacity > 0 {
+		if u.Refilled.IsZero() {
+			u.Tokens = capacity
+		} else {
+			u.Tokens += now.Sub(u.Refilled).Minutes() * capacity
+		}
+		u.Tokens = math.Min(u.Tokens, capacity)
+	}
+	u.Refilled = now
+	if day := now.Format("2006-01-02"); u.Day != day {
+		u.Day, u.DayChars = day, 0
+	}
+	if month := now.Format("2006-01"); u.Month != month {
+		u.Month, u.MonthChars = month, 0
+	}
+	return u
+}
+
+// quotaLocked refuses chars more characters that would go over a quota.
+func quotaLocked(u *clientUsage, lim clientLimits, chars int64, now time.Time) error {
+	if lim.DailyChars > 0 && u.DayChars+chars > lim.DailyChars {
+		tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		return &limitError{
+			message:    fmt.Sprintf("daily character quota exceeded: %d of %d used", u.DayChars, lim.DailyChars),
+			retryAfter: tomorrow.Sub(now),
+		}
+	}
+	if lim.MonthlyChars > 0 && u.MonthChars+chars > lim.MonthlyChars {
+		nextMonth := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		return &limitError{
+			message:    fmt.Sprintf("monthly character quota exceeded: %d of %d used", u.MonthChars, lim.MonthlyChars),
+			retryAfter: nextMonth.Sub(now),
+		}
+	}
+	return nil
+}
+
+// admit takes one request from client id's bucket and charges chars
+// characters to its quotas, or refuses with a limitError and charges
+// nothing. A session counts against the concurrency limit until release.
+func (t *usageTracker) admit(id string, lim clientLimits, chars int64, session bool) (release func(), err error) {
+	now := time.Now().UTC()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	u := t.clientLocked(id, lim, now)
+
+	if session && lim.Concurrent > 0 && u.active >= lim.Concurrent {
+		return nil, &limitError{
+			message:    fmt.Sprintf("too many concurrent requests: limit %d", lim.Concurrent),
+			retryAfter: time.Second,
+		}
+	}
+	if err := quotaLocked(u, lim, chars, now); err != nil {
+		return nil, err
+	}
+	if lim.RequestsPerMinute > 0 {
+		if u.Tokens < 1 {
+			return nil, &limitError{
+				message:    fmt.Sprintf("rate limit exceeded: %d requests per minute", lim.RequestsPerMinute),
+				retryAfter: time.Duration((1 - u.Tokens) / float64(lim.RequestsPerMinute) * float64(time.Minute)),
+			}
+		}
+		u.Tokens--
+	}
+	u.DayChars += chars
+	u.MonthChars += chars
+	t.dirty = true
+
+	if !session {
+		return func() {}, nil
+	}
+	u.active++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mu.Lock()
+			u.active--
+			t.mu.Unlock()
+		})
+	}, nil
+}
+
+// charge adds chars characters to client id's quotas without taking a
+// request, for text arriving on an already admitted stream.
+func (t *usageTracker) charge(id string, lim clientLimits, chars int64) error {
+	now := time.Now().UTC()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	u := t.clientLocked(id, lim, now)
+	if err := quotaLocked(u, lim, chars, now); err != nil {
+		return err
+	}
+	u.DayChars += chars
+	u.MonthChars += chars
+	t.dirty = true
+	return nil
+}
+
+// refund gives back chars characters charged to client id at time at,
+// for a synthesis that failed or was canceled. A day or month that has
+// rolled over since keeps its count.
+func (t *usageTracker) refund(id string, chars int64, at time.Time) {
+	if chars <= 0 {
+		return
+	}
+	at = at.UTC()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	u, ok := t.clients[id]
+	if !ok {
+		return
+	}
+	if u.Day == at.Format("2006-01-02") {
+		u.DayChars = max(u.DayChars-chars, 0)
+	}
+	if u.Month == at.Format("2006-01") {
+		u.MonthChars = max(u.MonthChars-chars, 0)
+	}
+	t.dirty = true
+}
+
+// clientID identifies the caller for limits: the client key's name, or
+// the address the request came from.
+func (a callerAuth) clientID(r *http.Request) string {
+	if a.client != nil {
+		return "key:" + a.client.Name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func (l clientLimits) validate() error {
+	if l.RequestsPerMinute < 0 || l.Concurrent < 0 || l.DailyChars < 0 || l.MonthlyChars < 0 {
+		return errors.New("limits must not be negative")
+	}
+	return nil
+}
+
+func (a callerAuth) limits() clientLimits {
+	if a.client != nil && a.client.Limits != nil {
+		return *a.client.Limits
+	}
+	return defaultLimits
+}
+
+// admission is a request let in by admitRequest. It holds a concurrency
+// slot until done, takes its characters from the quotas with charge and
+// can give them back with refund. A nil admission, as when usage is not
+// tracked, does nothing.
+type admission struct {
+	release  func()
+	id       string
+	lim      clientLimits
+	chars    int64
+	at       time.Time
+	charged  atomic.Bool
+	refunded atomic.Bool
+}
+
+func (a *admission) done() {
+	if a != nil {
+		a.release()
+	}
+}
+
+// charge takes the request's characters from the quotas once the audio
+// turned out not to be cached, so replaying cached text costs nothing.
+func (a *admission) charge(r *http.Request) error {
+	if a == nil || a.charged.Load() {
+		return nil
+	}
+	if err := usage.charge(a.id, a.lim, a.chars); err != nil {
+		reqLog(r).Infof("client %s refused: %v", a.id, err)
+		return err
+	}
+	a.at = time.Now()
+	a.charged.Store(true)
+	return nil
+}
+
+// refund returns the characters of a request whose synthesis failed, so
+// only audio that was produced counts against the quotas.
+func (a *admission) refund() {
+	if a != nil && a.charged.Load() && a.refunded.CompareAndSwap(false, true) {
+		usage.refund(a.id, a.chars, a.at)
+	}
+}
+
+// admitRequest admits a request to synthesize text for the caller against
+// the request rate and concurrency limits. The characters are only
+// charged by admission.charge, after the cache lookup.
+func admitRequest(r *http.Request, auth callerAuth, text string) (*admission, error) {
+	if usage == nil {
+		return nil, nil
+	}
+	a := &admission{
+		id:    auth.clientID(r),
+		lim:   auth.limits(),
+		chars: int64(utf8.RuneCountInString(text)),
+	}
+	release, err := usage.admit(a.id, a.lim, 0, true)
+	if err != nil {
+		reqLog(r).Infof("client %s refused: %v", a.id, err)
+		return nil, err
+	}
+	a.release = release
+	return a, nil
+}
+
+// setRetryAfter tells a caller refused by a limit when to come back.
+func setRetryAfter(w http.ResponseWriter, err error) {
+	var limErr *limitError
+	if errors.As(err, &limErr) {
+		secs := int64(math.Ceil(limErr.retryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.FormatInt(max(secs, 1), 10))
+	}
+}
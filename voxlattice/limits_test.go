@@ -0,0 +1,263 @@
+package voxlattice
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestTracker(t *testing.T) *usageTracker {
+	t.Helper()
+	tr, err := newUsageTracker(filepath.Join(t.TempDir(), "usage.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tr
+}
+
+func TestUsageAdmit(t *testing.T) {
+	tests := []struct {
+		name  string
+		lim   clientLimits
+		chars []int64 // one admitted session per entry, none released
+		want  []bool  // whether each was let in
+	}{
+		{"no limits", clientLimits{}, []int64{1000, 1000, 1000}, []bool{true, true, true}},
+		{"rate", clientLimits{RequestsPerMinute: 2}, []int64{1, 1, 1}, []bool{true, true, false}},
+		{"concurrent", clientLimits{Concurrent: 1}, []int64{1, 1}, []bool{true, false}},
+		{"daily", clientLimits{DailyChars: 10}, []int64{6, 5, 4}, []bool{true, false, true}},
+		{"monthly", clientLimits{MonthlyChars: 10}, []int64{10, 1}, []bool{true, false}},
+		{"refused charges nothing", clientLimits{DailyChars: 10, RequestsPerMinute: 2}, []int64{11, 10, 1}, []bool{false, true, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newTestTracker(t)
+			for i, chars := range tt.chars {
+				_, err := tr.admit("c", tt.lim, chars, true)
+				if got := err == nil; got != tt.want[i] {
+					t.Fatalf("request %d: admitted = %v (%v), want %v", i+1, got, err, tt.want[i])
+				}
+				var limErr *limitError
+				if err != nil && (!errors.As(err, &limErr) || limErr.retryAfter <= 0) {
+					t.Errorf("request %d: error %v has no retry delay", i+1, err)
+				}
+			}
+		})
+	}
+}
+
+func TestUsageRelease(t *testing.T) {
+	tr := newTestTracker(t)
+	lim := clientLimits{Concurrent: 1}
+	release, err := tr.admit("c", lim, 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	release() // a second call must not free another slot
+	if _, err := tr.admit("c", lim, 1, true); err != nil {
+		t.Fatalf("admit after release: %v", err)
+	}
+	if _, err := tr.admit("c", lim, 1, true); err == nil {
+		t.Fatal("second concurrent session admitted")
+	}
+}
+
+func TestClientLockedRefill(t *testing.T) {
+	start := time.Date(2024, 5, 31, 23, 59, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		tokens  float64 // left after the first call
+		elapsed time.Duration
+		want    float64
+	}{
+		{"full bucket stays full", 60, 10 * time.Second, 60},
+		{"refills pro rata", 0, 30 * time.Second, 30},
+		{"capped at capacity", 10, time.Hour, 60},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newTestTracker(t)
+			lim := clientLimits{RequestsPerMinute: 60}
+			u := tr.clientLocked("c", lim, start)
+			if u.Tokens != 60 {
+				t.Fatalf("new client has %v tokens, want 60", u.Tokens)
+			}
+			u.Tokens = tt.tokens
+			u = tr.clientLocked("c", lim, start.Add(tt.elapsed))
+			if u.Tokens != tt.want {
+				t.Errorf("tokens = %v, want %v", u.Tokens, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientLockedRollover(t *testing.T) {
+	tr := newTestTracker(t)
+	now := time.Date(2024, 5, 31, 23, 0, 0, 0, time.UTC)
+	u := tr.clientLocked("c", clientLimits{}, now)
+	u.DayChars, u.MonthChars = 5, 7
+
+	u = tr.clientLocked("c", clientLimits{}, now.Add(30*time.Minute))
+	if u.DayChars != 5 || u.MonthChars != 7 {
+		t.Errorf("same day: counts %d/%d, want 5/7", u.DayChars, u.MonthChars)
+	}
+	u = tr.clientLocked("c", clientLimits{}, now.Add(2*time.Hour))
+	if u.Day != "2024-06-01" || u.DayChars != 0 || u.Month != "2024-06" || u.MonthChars != 0 {
+		t.Errorf("next month: got %+v, want both counts reset", u)
+	}
+}
+
+func TestQuotaRetryAfter(t *testing.T) {
+	now := time.Date(2024, 2, 28, 18, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		lim  clientLimits
+		want time.Duration
+	}{
+		{"daily waits for midnight", clientLimits{DailyChars: 10}, 6 * time.Hour},
+		{"monthly waits for the 1st", clientLimits{MonthlyChars: 10}, 30 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &clientUsage{DayChars: 10, MonthChars: 10}
+			var limErr *limitError
+			if err := quotaLocked(u, tt.lim, 1, now); !errors.As(err, &limErr) {
+				t.Fatalf("quotaLocked = %v, want a limitError", err)
+			}
+			if limErr.retryAfter != tt.want {
+				t.Errorf("retryAfter = %v, want %v", limErr.retryAfter, tt.want)
+			}
+		})
+	}
+}
+
+func TestUsageRefund(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		refund    int64
+		at        time.Time
+		wantDay   int64
+		wantMonth int64
+	}{
+		{"full", 8, now, 0, 0},
+		{"partial", 3, now, 5, 5},
+		{"floored at zero", 20, now, 0, 0},
+		{"negative ignored", -5, now, 8, 8},
+		{"earlier day", 8, now.AddDate(0, 0, -1), 8, 0},
+		{"earlier month", 8, now.AddDate(0, -1, 0), 8, 8},
+		{"local time", 8, now.In(time.FixedZone("UTC+9", 9*3600)), 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newTestTracker(t)
+			tr.clients["c"] = &clientUsage{Day: "2024-05-10", DayChars: 8, Month: "2024-05", MonthChars: 8}
+			tr.refund("c", tt.refund, tt.at)
+			tr.refund("unknown", tt.refund, tt.at)
+			u := tr.clients["c"]
+			if u.DayChars != tt.wantDay || u.MonthChars != tt.wantMonth {
+				t.Errorf("counts %d/%d, want %d/%d", u.DayChars, u.MonthChars, tt.wantDay, tt.wantMonth)
+			}
+			if _, ok := tr.clients["unknown"]; ok {
+				t.Error("refund created a client")
+			}
+		})
+	}
+}
+
+func TestAdmissionRefundOnce(t *testing.T) {
+	saved := usage
+	defer func() { usage = saved }()
+	usage = newTestTracker(t)
+
+	if err := usage.charge("c", clientLimits{}, 10); err != nil {
+		t.Fatal(err)
+	}
+	a := &admission{release: func() {}, id: "c", chars: 4, at: time.Now()}
+	a.refund()
+	if got := usage.clients["c"].DayChars; got != 10 {
+		t.Fatalf("day chars after refunding an uncharged admission = %d, want 10", got)
+	}
+	a.charged.Store(true)
+	a.refund()
+	a.refund()
+	if got := usage.clients["c"].DayChars; got != 6 {
+		t.Errorf("day chars after two refunds = %d, want 6", got)
+	}
+
+	var none *admission
+	none.refund()
+	none.done()
+}
+
+func TestClientLimitsValidate(t *testing.T) {
+	tests := []struct {
+		lim clientLimits
+		ok  bool
+	}{
+		{clientLimits{}, true},
+		{clientLimits{RequestsPerMinute: 1, Concurrent: 2, DailyChars: 3, MonthlyChars: 4}, true},
+		{clientLimits{RequestsPerMinute: -1}, false},
+		{clientLimits{Concurrent: -1}, false},
+		{clientLimits{DailyChars: -1}, false},
+		{clientLimits{MonthlyChars: -1}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.lim.validate(); (err == nil) != tt.ok {
+			t.Errorf("%+v.validate() = %v, want ok %v", tt.lim, err, tt.ok)
+		}
+	}
+}
+
+func TestRefundJobItem(t *testing.T) {
+	saved := usage
+	defer func() { usage = saved }()
+	usage = newTestTracker(t)
+
+	created := time.Now().UTC()
+	usage.clients["c"] = &clientUsage{Day: created.Format("2006-01-02"), DayChars: 10, Month: created.Format("2006-01"), MonthChars: 10}
+	// The voice may have been removed from Voices.json since the job was
+	// submitted; the refund must not depend on the request still passing.
+	j := &jobRecord{UsageID: "c", Created: created, Items: []jobItemRecord{
+		{Request: ttsReq{Text: "hello", Voice: "no-such-voice"}, Chars: 4},
+		{Request: ttsReq{Text: "world"}, Chars: 6},
+	}}
+	refundJobItem(j, 0)
+	refundJobItem(j, 0)
+	if got := usage.clients["c"].DayChars; got != 6 {
+		t.Errorf("day chars = %d, want 6 after refunding the first item once", got)
+	}
+	refundJobItem(j, 1)
+	if got := usage.clients["c"].DayChars; got != 0 {
+		t.Errorf("day chars = %d, want 0 after refunding both items", got)
+	}
+}
+
+func TestCacheHitChargesNoQuota(t *testing.T) {
+	useFakeBackend(t)
+	useCache(t)
+	savedUsage, savedLimits := usage, defaultLimits
+	defer func() { usage, defaultLimits = savedUsage, savedLimits }()
+	usage = newTestTracker(t)
+	defaultLimits = clientLimits{DailyChars: 5}
+
+	body := `{"text":"hello","format":"wav"}`
+	for i, want := range []string{"MISS", "HIT", "HIT"} {
+		w := serve(ttsHandler, http.MethodPost, "/tts", body)
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d: %s", i+1, w.Code, w.Body)
+		}
+		if got := w.Header().Get("X-Cache"); got != want {
+			t.Errorf("request %d: X-Cache %q, want %q", i+1, got, want)
+		}
+		if got := usage.clients["ip:192.0.2.1"].DayChars; got != 5 {
+			t.Errorf("request %d: day chars = %d, want 5", i+1, got)
+		}
+	}
+	if w := serve(ttsHandler, http.MethodPost, "/tts", `{"text":"world","format":"wav"}`); w.Code != http.StatusTooManyRequests {
+		t.Errorf("uncached request over quota: status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+}
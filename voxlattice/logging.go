@@ -1,13 +1,20 @@
 package voxlattice
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"net/http"
 	"os"
-	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 type logLevel int
@@ -19,19 +26,30 @@ const (
 	levelError
 )
 
+// appLogger writes leveled lines as text or, with --log-format json, one
+// JSON object per line. Loggers derived with with share the output and
+// add their fields to every line.
 type appLogger struct {
 	min    logLevel
-	logger *log.Logger
+	json   bool
+	out    *logOutput
+	fields []logField
 }
 
-type rotatingFileWriter struct {
-	mu   sync.Mutex
-	file *os.File
-	size int64
-	max  int64
+type logOutput struct {
+	mu sync.Mutex
+	w  io.Writer
 }
 
-var appLog = newAppLogger(levelWarn, os.Stdout)
+type logField struct {
+	key   string
+	value any
+}
+
+var appLog = newAppLogger(levelWarn, false, os.Stdout)
+
+// logAccess turns the per-request access log on (--access-log).
+var logAccess = true
 
 func (l logLevel) String() string {
 	switch l {
@@ -63,19 +81,97 @@ func parseLogLevel(s string) (logLevel, error) {
 	}
 }
 
-func newAppLogger(level logLevel, out io.Writer) *appLogger {
+func parseLogFormat(s string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "text":
+		return false, nil
+	case "json":
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid log format: %s", s)
+	}
+}
+
+func newAppLogger(level logLevel, json bool, out io.Writer) *appLogger {
 	return &appLogger{
-		min:    level,
-		logger: log.New(out, "", log.LstdFlags),
+		min:  level,
+		json: json,
+		out:  &logOutput{w: out},
 	}
 }
 
+// with returns a logger that adds the key, value pairs kv to every line.
+func (l *appLogger) with(kv ...any) *appLogger {
+	derived := *l
+	derived.fields = append(slices.Clip(l.fields), pairFields(kv)...)
+	return &derived
+}
+
+func pairFields(kv []any) []logField {
+	fields := make([]logField, 0, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		fields = append(fields, logField{key: fmt.Sprint(kv[i]), value: kv[i+1]})
+	}
+	return fields
+}
+
 func (l *appLogger) logf(level logLevel, format string, args ...interface{}) {
 	if l == nil || level < l.min {
 		return
 	}
-	prefix := level.String()
-	l.logger.Printf("[%s] "+format, append([]interface{}{prefix}, args...)...)
+	l.write(level, fmt.Sprintf(format, args...), nil)
+}
+
+// access writes an access log entry whatever the level.
+func (l *appLogger) access(msg string, kv ...any) {
+	if l == nil || !logAccess {
+		return
+	}
+	l.write(levelInfo, msg, pairFields(kv))
+}
+
+func (l *appLogger) write(level logLevel, msg string, extra []logField) {
+	now := time.Now()
+	var b bytes.Buffer
+	if l.json {
+		b.WriteString(`{"time":`)
+		writeJSONValue(&b, now.UTC().Format(time.RFC3339Nano))
+		b.WriteString(`,"level":`)
+		writeJSONValue(&b, level.String())
+		b.WriteString(`,"msg":`)
+		writeJSONValue(&b, msg)
+		for _, f := range slices.Concat(l.fields, extra) {
+			b.WriteByte(',')
+			writeJSONValue(&b, f.key)
+			b.WriteByte(':')
+			writeJSONValue(&b, f.value)
+		}
+		b.WriteString("}\n")
+	} else {
+		fmt.Fprintf(&b, "%s [%s] %s", now.Format("2006/01/02 15:04:05"), level, msg)
+		for _, f := range slices.Concat(l.fields, extra) {
+			v := fmt.Sprint(f.value)
+			if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+				v = strconv.Quote(v)
+			}
+			fmt.Fprintf(&b, " %s=%s", f.key, v)
+		}
+		b.WriteByte('\n')
+	}
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	_, _ = l.out.w.Write(b.Bytes())
+}
+
+func writeJSONValue(b *bytes.Buffer, v any) {
+	if err, ok := v.(error); ok {
+		v = err.Error()
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	b.Write(data)
 }
 
 func (l *appLogger) Debugf(format string, args ...interface{}) { l.logf(levelDebug, format, args...) }
@@ -87,70 +183,64 @@ func (l *appLogger) Fatalf(format string, args ...interface{}) {
 	os.Exit(1)
 }
 
-func newRotatingFileWriter(path string, maxBytes int64) (*rotatingFileWriter, error) {
-	dir := filepath.Dir(path)
-	if dir != "" && dir != "." {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return nil, err
-		}
-	}
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
-	if err != nil {
-		return nil, err
-	}
-	info, err := file.Stat()
-	if err != nil {
-		_ = file.Close()
-		return nil, err
-	}
-	size := info.Size()
-	if size >= maxBytes {
-		if err := file.Truncate(0); err != nil {
-			_ = file.Close()
-			return nil, err
-		}
-		if _, err := file.Seek(0, 0); err != nil {
-			_ = file.Close()
-			return nil, err
-		}
-		size = 0
-	}
-	return &rotatingFileWriter{
-		file: file,
-		size: size,
-		max:  maxBytes,
-	}, nil
-}
-
-func (w *rotatingFileWriter) Write(p []byte) (int, error) {
-	w.mu.Lock()
-	defer w.mu.Unlock()
-	if w.file == nil {
-		return 0, fmt.Errorf("log file not available")
-	}
-	if w.size+int64(len(p)) > w.max {
-		if err := w.file.Truncate(0); err != nil {
-			return 0, err
-		}
-		if _, err := w.file.Seek(0, 0); err != nil {
-			return 0, err
-		}
-		w.size = 0
-	}
-	n, err := w.file.Write(p)
-	w.size += int64(n)
-	return n, err
-}
+// The log file when --log is set, for reopenLogFile
+var logFile *rotatingFileWriter
 
-func initLogger(path string, level logLevel) error {
+func initLogger(path string, level logLevel, json bool, rot logRotation) error {
 	var out io.Writer = os.Stdout
 	if path != "" {
-		writer, err := newRotatingFileWriter(path, defaultLogMaxBytes)
+		writer, err := newRotatingFileWriter(path, rot)
 		if err != nil {
 			return err
 		}
+		logFile = writer
 		out = writer
 	}
-	appLog = newAppLogger(level, out)
+	appLog = newAppLogger(level, json, out)
 	return nil
 }
+
+// reopenLogFile starts writing to a new file at the log path after an
+// external tool such as logrotate moved the old one away.
+func reopenLogFile() {
+	if logFile == nil {
+		return
+	}
+	if err := logFile.reopen(); err != nil {
+		fmt.Fprintln(os.Stderr, "reopen log file failed:", err)
+		return
+	}
+	appLog.Infof("Log file reopened")
+}
+
+type loggerKey struct{}
+
+// withLogger returns ctx carrying l, the logger for work done on its behalf.
+func withLogger(ctx context.Context, l *appLogger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// logFrom returns the logger of a request's ctx, or appLog outside one.
+func logFrom(ctx context.Context) *appLogger {
+	if l, ok := ctx.Value(loggerKey{}).(*appLogger); ok {
+		return l
+	}
+	return appLog
+}
+
+// reqLog returns the logger of request r, which tags lines with its ID.
+func reqLog(r *http.Request) *appLogger {
+	return logFrom(r.Context())
+}
+
+// requestID returns the X-Request-ID of r, or a new one when the caller
+// sent none or one that is too long or not plain printable text.
+func requestID(r *http.Request) string {
+	id := strings.TrimSpace(r.Header.Get("X-Request-ID"))
+	if id == "" || len(id) > 128 || strings.IndexFunc(id, func(c rune) bool { return c <= ' ' || c > '~' }) >= 0 {
+		b := make([]byte, 16)
+		_, _ = rand.Read(b)
+		return hex.EncodeToString(b)
+	}
+	return id
+}
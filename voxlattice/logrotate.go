@@ -0,0 +1,290 @@
+package voxlattice
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logRotation limits how much log history is kept on disk.
+type logRotation struct {
+	maxBytes   int64         // size past which the file is rotated
+	maxBackups int           // rotated files kept, 0 keeps none
+	maxAge     time.Duration // rotated files older than this are removed, 0 keeps them
+	compress   bool          // gzip every backup but the newest
+}
+
+func (r logRotation) validate() error {
+	switch {
+	case r.maxBytes <= 0:
+		return fmt.Errorf("log file size limit must be positive")
+	case r.maxBackups < 0:
+		return fmt.Errorf("log backups must not be negative")
+	case r.maxAge < 0:
+		return fmt.Errorf("log max age must not be negative")
+	}
+	return nil
+}
+
+// rotatingFileWriter appends to a log file. When a write would take it past
+// maxBytes the file is renamed to <path>.1, older backups move up one to
+// <path>.2 and so on, and a new file is started. With compression on,
+// backups past the first are gzipped to <path>.N.gz in the background.
+type rotatingFileWriter struct {
+	mu   sync.Mutex
+	path string
+	rot  logRotation
+	file *os.File
+	size int64
+
+	compressing bool           // a compressBackups goroutine is running
+	compressed  sync.WaitGroup // done when it has finished
+}
+
+func newRotatingFileWriter(path string, rot logRotation) (*rotatingFileWriter, error) {
+	dir := filepath.Dir(path)
+	if dir != "" && dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	w := &rotatingFileWriter{path: path, rot: rot}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.size >= rot.maxBytes {
+		if err := w.rotate(); err != nil {
+			return nil, err
+		}
+	} else {
+		w.prune()
+		// Backups left uncompressed by an earlier run or setting
+		w.startCompress()
+	}
+	return w, nil
+}
+
+func (w *rotatingFileWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	w.file, w.size = file, info.Size()
+	return nil
+}
+
+func (w *rotatingFileWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		// Opening failed after the last rotation or reopen; try again
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+	if w.size > 0 && w.size+int64(len(p)) > w.rot.maxBytes {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// reopen closes the file and opens the path again, so lines go to a new
+// file once something else has renamed the old one.
+func (w *rotatingFileWriter) reopen() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file != nil {
+		_ = w.file.Close()
+		w.file = nil
+	}
+	return w.open()
+}
+
+// rotate moves the current file to the first backup and starts a new one.
+// Only renames happen here, under w.mu; compression runs afterwards in the
+// background. When the backups cannot be shifted the current file is kept
+// growing rather than losing lines.
+func (w *rotatingFileWriter) rotate() error {
+	_ = w.file.Close()
+	w.file = nil
+	if err := w.shiftBackups(); err != nil {
+		fmt.Fprintln(os.Stderr, "log rotation failed:", err)
+	}
+	w.prune()
+	w.startCompress()
+	return w.open()
+}
+
+// backupName is the name of the n-th newest backup before compression.
+func (w *rotatingFileWriter) backupName(n int) string {
+	return w.path + "." + strconv.Itoa(n)
+}
+
+// shiftBackups moves every backup up one, compressed or not, so turning
+// compression on or off leaves nothing behind; the one pushed past
+// maxBackups is removed by prune.
+func (w *rotatingFileWriter) shiftBackups() error {
+	if w.rot.maxBackups == 0 {
+		return os.Remove(w.path)
+	}
+	for n := w.rot.maxBackups; n >= 1; n-- {
+		for _, ext := range []string{"", ".gz"} {
+			src := w.backupName(n) + ext
+			if _, err := os.Stat(src); err != nil {
+				continue
+			}
+			if err := os.Rename(src, w.backupName(n+1)+ext); err != nil {
+				return err
+			}
+		}
+	}
+	return os.Rename(w.path, w.backupName(1))
+}
+
+// prune removes backups beyond maxBackups and those older than maxAge,
+// including ones left by earlier settings.
+func (w *rotatingFileWriter) prune() {
+	matches, err := filepath.Glob(w.path + ".*")
+	if err != nil {
+		return
+	}
+	for _, name := range matches {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(name, w.path+"."), ".gz")
+		n, err := strconv.Atoi(suffix)
+		if err != nil || n < 1 {
+			continue
+		}
+		expired := n > w.rot.maxBackups
+		if !expired && w.rot.maxAge > 0 {
+			if info, err := os.Stat(name); err == nil {
+				expired = time.Since(info.ModTime()) > w.rot.maxAge
+			}
+		}
+		if expired {
+			if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+				fmt.Fprintln(os.Stderr, "log backup remove failed:", err)
+			}
+		}
+	}
+}
+
+// startCompress starts gzipping backups in the background unless that is
+// off or already running. Callers hold w.mu.
+func (w *rotatingFileWriter) startCompress() {
+	if !w.rot.compress || w.compressing {
+		return
+	}
+	w.compressing = true
+	w.compressed.Add(1)
+	go w.compressBackups()
+}
+
+// compressBackups gzips uncompressed backups past the first, oldest first,
+// until none are left.
+func (w *rotatingFileWriter) compressBackups() {
+	defer w.compressed.Done()
+	for {
+		w.mu.Lock()
+		name := w.uncompressedBackup()
+		if name == "" {
+			w.compressing = false
+			w.mu.Unlock()
+			return
+		}
+		w.mu.Unlock()
+		if err := w.compressBackup(name); err != nil {
+			fmt.Fprintln(os.Stderr, "log backup compression failed:", err)
+			w.mu.Lock()
+			w.compressing = false
+			w.mu.Unlock()
+			return
+		}
+	}
+}
+
+// uncompressedBackup returns the oldest backup that should be compressed,
+// or "" if there is none. Callers hold w.mu.
+func (w *rotatingFileWriter) uncompressedBackup() string {
+	for n := w.rot.maxBackups; n >= 2; n-- {
+		if _, err := os.Stat(w.backupName(n)); err == nil {
+			return w.backupName(n)
+		}
+	}
+	return ""
+}
+
+// compressBackup gzips one backup without holding w.mu, so a rotation can
+// shift or prune it meanwhile. The file is read whole instead of being kept
+// open, since Windows cannot rename an open file. The result replaces the
+// backup wherever it has moved to, and is dropped if it is gone. It keeps
+// the backup's modification time so age pruning still sees when it was
+// written.
+func (w *rotatingFileWriter) compressBackup(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	info, err := f.Stat()
+	var data []byte
+	if err == nil {
+		data, err = io.ReadAll(f)
+	}
+	_ = f.Close()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(w.path), filepath.Base(w.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(tmp)
+	_, err = zw.Write(data)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chtimes(tmp.Name(), info.ModTime(), info.ModTime())
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for n := 2; n <= w.rot.maxBackups; n++ {
+		current := w.backupName(n)
+		if st, err := os.Stat(current); err == nil && os.SameFile(info, st) {
+			if err := os.Rename(tmp.Name(), current+".gz"); err != nil {
+				_ = os.Remove(tmp.Name())
+				return err
+			}
+			return os.Remove(current)
+		}
+	}
+	return os.Remove(tmp.Name())
+}
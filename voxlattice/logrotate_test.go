@@ -0,0 +1,114 @@
+package voxlattice
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// backupContents reads every file in dir, decompressing .gz files.
+func backupContents(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := map[string]string{}
+	for _, e := range entries {
+		f, err := os.Open(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r io.Reader = f
+		if strings.HasSuffix(e.Name(), ".gz") {
+			if r, err = gzip.NewReader(f); err != nil {
+				t.Fatalf("%s: %v", e.Name(), err)
+			}
+		}
+		data, err := io.ReadAll(r)
+		_ = f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[e.Name()] = string(data)
+	}
+	return out
+}
+
+func TestRotatingFileWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		compress bool
+		existing map[string]string // files present before the writer opens
+		want     map[string]string
+	}{
+		{
+			name: "plain",
+			want: map[string]string{"app.log": "e", "app.log.1": "d", "app.log.2": "c", "app.log.3": "b"},
+		},
+		{
+			name:     "compressed",
+			compress: true,
+			want:     map[string]string{"app.log": "e", "app.log.1": "d", "app.log.2.gz": "c", "app.log.3.gz": "b"},
+		},
+		{
+			name:     "compression turned on",
+			compress: true,
+			existing: map[string]string{"app.log.1": "y", "app.log.2": "x"},
+			want:     map[string]string{"app.log": "c", "app.log.1": "b", "app.log.2.gz": "a", "app.log.3.gz": "y"},
+		},
+		{
+			name:     "compression turned off",
+			existing: map[string]string{"app.log.1": "y", "app.log.2.gz": "x"},
+			want:     map[string]string{"app.log": "c", "app.log.1": "b", "app.log.2": "a", "app.log.3": "y"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.existing {
+				if strings.HasSuffix(name, ".gz") {
+					f, err := os.Create(filepath.Join(dir, name))
+					if err != nil {
+						t.Fatal(err)
+					}
+					zw := gzip.NewWriter(f)
+					_, _ = zw.Write([]byte(content))
+					_ = zw.Close()
+					_ = f.Close()
+				} else if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			w, err := newRotatingFileWriter(filepath.Join(dir, "app.log"), logRotation{maxBytes: 1, maxBackups: 3, compress: tt.compress})
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := "abcde"
+			if tt.existing != nil {
+				lines = "abc"
+			}
+			for _, line := range lines {
+				if _, err := w.Write([]byte(string(line))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			w.compressed.Wait()
+			_ = w.file.Close()
+
+			got := backupContents(t, dir)
+			if !slices.Equal(sortedKeys(got), sortedKeys(tt.want)) {
+				t.Fatalf("files %v, want %v", sortedKeys(got), sortedKeys(tt.want))
+			}
+			for name, content := range tt.want {
+				if got[name] != content {
+					t.Errorf("%s holds %q, want %q", name, got[name], content)
+				}
+			}
+		})
+	}
+}
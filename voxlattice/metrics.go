@@ -0,0 +1,433 @@
+package voxlattice
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Latency buckets in seconds, from a quick cache-warm reply to a long
+// chunked synthesis
+var latencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2, 4, 8, 15, 30, 60, 120}
+
+// Audio length buckets in seconds
+var audioBuckets = []float64{1, 2, 5, 10, 20, 30, 60, 120, 300, 600}
+
+var (
+	httpRequests = newCounterVec("voxlattice_http_requests_total",
+		"HTTP requests by route, status code and voice.", "route", "status", "voice")
+	inputChars = newCounterVec("voxlattice_input_characters_total",
+		"Characters of text accepted for synthesis.", "route")
+	synthErrors = newCounterVec("voxlattice_synthesis_errors_total",
+		"Failed syntheses by route and the step that failed.", "route", "stage")
+	firstAudio = newHistogramVec("voxlattice_upstream_first_audio_seconds",
+		"Time from an upstream call to its first audio byte.", latencyBuckets, "model")
+	upstreamDuration = newHistogramVec("voxlattice_upstream_duration_seconds",
+		"Duration of upstream synthesis calls.", latencyBuckets, "model")
+	outputAudio = newHistogramVec("voxlattice_output_audio_seconds",
+		"Seconds of audio produced by each upstream call.", audioBuckets, "model")
+	liveSessions atomic.Int64
+)
+
+// counterVec is a Prometheus counter with labels.
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	values map[string]float64 // by escaped label set
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+}
+
+func (c *counterVec) add(v float64, labelValues ...string) {
+	key := labelSet(c.labels, labelValues)
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+func (c *counterVec) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s{%s} %s\n", c.name, key, formatFloat(c.values[key]))
+	}
+}
+
+// histogramVec is a Prometheus histogram with labels.
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogram{}}
+}
+
+func (h *histogramVec) observe(v float64, labelValues ...string) {
+	key := labelSet(h.labels, labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket{%s,le=%q} %d\n", h.name, key, formatFloat(le), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", h.name, key, s.count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", h.name, key, formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", h.name, key, s.count)
+	}
+}
+
+// labelSet renders label pairs the way they appear between the braces.
+func labelSet(names, values []string) string {
+	var b strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(v))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string { return labelEscaper.Replace(v) }
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// metricsToken, when set, is the bearer token /metrics requires.
+func metricsToken() string {
+	return strings.TrimSpace(os.Getenv("AUDIOMESH_METRICS_TOKEN"))
+}
+
+// metricsHandler serves GET /metrics in the Prometheus text format.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+	if token := metricsToken(); token != "" {
+		auth := strings.TrimSpace(r.Header.Get("Authorization"))
+		if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimSpace(auth[7:])), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "invalid metrics token", http.StatusUnauthorized)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	httpRequests.write(bw)
+	inputChars.write(bw)
+	synthErrors.write(bw)
+	firstAudio.write(bw)
+	upstreamDuration.write(bw)
+	outputAudio.write(bw)
+	fmt.Fprintf(bw, "# HELP voxlattice_live_sessions Gemini Live sessions currently open.\n# TYPE voxlattice_live_sessions gauge\nvoxlattice_live_sessions %d\n", liveSessions.Load())
+	_ = bw.Flush()
+}
+
+// requestInfo is what a handler learns about its request that the metrics
+// and access log recorded around it need.
+type requestInfo struct {
+	route  string
+	mu     sync.Mutex
+	voice  string
+	chars  int
+	client string
+}
+
+type requestInfoKey struct{}
+
+func requestInfoFrom(r *http.Request) *requestInfo {
+	info, _ := r.Context().Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// instrument counts the requests h serves under route by status and voice,
+// and traces each one in a server span continuing the caller's traceparent.
+// Every request gets an X-Request-ID, kept from the caller when valid, that
+// tags its log lines, and an access log entry once it is served.
+func instrument(route string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := requestID(r)
+		w.Header().Set("X-Request-ID", id)
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", route),
+			),
+		)
+		defer span.End()
+
+		logger := appLog.with("request_id", id)
+		if sc := span.SpanContext(); sc.IsValid() {
+			logger = logger.with("trace_id", sc.TraceID().String())
+		}
+		ctx = withLogger(ctx, logger)
+
+		info := &requestInfo{route: route}
+		sw := &statusWriter{ResponseWriter: w}
+		h(sw, r.WithContext(context.WithValue(ctx, requestInfoKey{}, info)))
+
+		status := sw.statusCode()
+		span.SetAttributes(
+			attribute.Int("http.response.status_code", status),
+			attribute.Int("http.response.body.size", sw.written),
+		)
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+		info.mu.Lock()
+		voice, client := info.voice, info.client
+		info.mu.Unlock()
+		httpRequests.add(1, route, strconv.Itoa(status), voice)
+		if client == "" {
+			client = "-"
+		}
+		logger.access("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"bytes", sw.written,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"client", client,
+			"remote", r.RemoteAddr,
+		)
+	}
+}
+
+// noteClient records the name of the client key a request was made with.
+func noteClient(r *http.Request, name string) {
+	if info := requestInfoFrom(r); info != nil {
+		info.mu.Lock()
+		info.client = name
+		info.mu.Unlock()
+	}
+}
+
+// noteSpeech records the voice of a request and counts the characters it
+// asked to have spoken. Streams call it again for every piece of text.
+func noteSpeech(r *http.Request, voice, text string) {
+	n := len([]rune(text))
+	route := "-"
+	if info := requestInfoFrom(r); info != nil {
+		info.mu.Lock()
+		if voice != "" {
+			info.voice = voice
+		}
+		info.chars += n
+		voice, total := info.voice, info.chars
+		info.mu.Unlock()
+		route = info.route
+		trace.SpanFromContext(r.Context()).SetAttributes(
+			attribute.String("tts.voice", voice),
+			attribute.Int("tts.text_length", total),
+		)
+	}
+	if n > 0 {
+		inputChars.add(float64(n), route)
+	}
+}
+
+// countSynthError counts a failed synthesis of route by the step that
+// failed. Callers that went away are not counted.
+func countSynthError(route string, err error) {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+	synthErrors.add(1, route, errorStage(err))
+}
+
+// countRequestError is countSynthError for the route r is served under.
+func countRequestError(r *http.Request, err error) {
+	if r.Context().Err() == context.Canceled {
+		return
+	}
+	route := "-"
+	if info := requestInfoFrom(r); info != nil {
+		route = info.route
+	}
+	countSynthError(route, err)
+}
+
+// errorStage names where a synthesis failed.
+func errorStage(err error) string {
+	var synthErr *synthError
+	var encErr *encodeError
+	var fidErr *fidelityError
+	switch {
+	case errors.As(err, &synthErr):
+		switch synthErr.stage {
+		case "client init":
+			return "client_init"
+		case "live connect":
+			return "connect"
+		case "clientContent send":
+			return "send"
+		case "read", "generate":
+			return "receive"
+		}
+		return strings.ReplaceAll(synthErr.stage, " ", "_")
+	case errors.As(err, &encErr):
+		return "encode"
+	case errors.As(err, &fidErr):
+		return "transcript"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	}
+	return "other"
+}
+
+// observeUpstream starts one upstream call for model in a span under ctx
+// and wraps its emit. done records how long the call took, when its first
+// audio arrived and how much audio it produced, and ends the span.
+func observeUpstream(ctx context.Context, model string, emit func([]byte) error, attrs ...attribute.KeyValue) (context.Context, func([]byte) error, func(error)) {
+	model = strings.TrimPrefix(model, "models/")
+	ctx, span := startSpan(ctx, "tts.upstream", attrs...)
+	start := time.Now()
+	var bytes int
+	wrapped := func(pcm []byte) error {
+		if bytes == 0 && len(pcm) > 0 {
+			firstAudio.observe(time.Since(start).Seconds(), model)
+			span.AddEvent("first audio")
+		}
+		bytes += len(pcm)
+		return emit(pcm)
+	}
+	done := func(err error) {
+		upstreamDuration.observe(time.Since(start).Seconds(), model)
+		if bytes > 0 {
+			outputAudio.observe(float64(bytes)/(sampleRateHz*2), model)
+		}
+		span.SetAttributes(attribute.Int("tts.audio_bytes", bytes))
+		endSpan(span, err)
+	}
+	return ctx, wrapped, done
+}
+
+// statusWriter remembers the status code of a response. It passes on
+// Flush and Hijack so streaming and WebSocket handlers keep working.
+type statusWriter struct {
+	http.ResponseWriter
+	status  int
+	written int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.written += n
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not support hijacking")
+	}
+	// A hijacked connection is reported as a protocol switch
+	w.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+func (w *statusWriter) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
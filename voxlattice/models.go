@@ -0,0 +1,102 @@
+package voxlattice
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// Gemini transports a model can be driven through
+const (
+	transportLive     = "live"     // Live API session, model repeats the text
+	transportGenerate = "generate" // generateContent with an AUDIO response
+)
+
+// Known Gemini speech models and the transport each one needs. Models not
+// listed here are classified by name in lookupModel.
+var modelRegistry = map[string]string{
+	"models/gemini-2.5-flash-native-audio-preview-12-2025": transportLive,
+	"models/gemini-2.5-flash-native-audio-preview-09-2025": transportLive,
+	"models/gemini-live-2.5-flash-preview":                 transportLive,
+	"models/gemini-2.0-flash-live-001":                     transportLive,
+	"models/gemini-2.5-flash-preview-tts":                  transportGenerate,
+	"models/gemini-2.5-pro-preview-tts":                    transportGenerate,
+}
+
+// canonicalModelName adds the "models/" prefix the SDK and registry use.
+func canonicalModelName(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" || strings.HasPrefix(name, "models/") {
+		return name
+	}
+	return "models/" + name
+}
+
+// lookupModel returns the transport for a model. Unlisted names ending in a
+// "-tts" tag map to generateContent and Live/native-audio names to the Live
+// API; anything else is reported as unknown.
+func lookupModel(name string) (string, bool) {
+	name = canonicalModelName(name)
+	if t, ok := modelRegistry[name]; ok {
+		return t, true
+	}
+	lower := strings.ToLower(name)
+	switch {
+	case strings.Contains(lower, "-tts"):
+		return transportGenerate, true
+	case strings.Contains(lower, "live"), strings.Contains(lower, "native-audio"):
+		return transportLive, true
+	}
+	return "", false
+}
+
+func getSupportedModelNames() []string {
+	names := make([]string, 0, len(modelRegistry))
+	for name := range modelRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// geminiSynthesizer routes each request to the Live or generateContent
+// backend according to the model registry. Unknown models, which can only
+// come from GEMINI_MODEL, keep using the Live API as before.
+type geminiSynthesizer struct{}
+
+func (geminiSynthesizer) requiresAPIKey() bool { return true }
+
+func (geminiSynthesizer) synthesize(ctx context.Context, req synthRequest, emit func([]byte) error) error {
+	if t, _ := lookupModel(req.Model); t == transportGenerate {
+		return generateSynthesizer{}.synthesize(ctx, req, emit)
+	}
+	return liveSynthesizer{}.synthesize(ctx, req, emit)
+}
+
+// transcribes is true for Live models; generateContent returns no transcript.
+func (geminiSynthesizer) transcribes(model string) bool {
+	t, _ := lookupModel(model)
+	return t != transportGenerate
+}
+
+// supportsDialogue reports whether req can be voiced natively: the TTS
+// models accept exactly two speakers and a script of limited length.
+func (geminiSynthesizer) supportsDialogue(req dialogueRequest) bool {
+	if t, _ := lookupModel(req.Model); t != transportGenerate {
+		return false
+	}
+	return len(dialogueSpeakers(req.Turns)) == 2 && len(dialogueScript(req.Turns)) <= maxNativeDialogueLen
+}
+
+func (geminiSynthesizer) synthesizeDialogue(ctx context.Context, req dialogueRequest, emit func([]byte) error) error {
+	return generateSynthesizer{}.synthesizeDialogue(ctx, req, emit)
+}
+
+// openSession keeps a Live session open for Live models; TTS models have no
+// session to keep, so each text becomes its own generateContent call.
+func (geminiSynthesizer) openSession(ctx context.Context, req synthRequest) (speechSession, error) {
+	if t, _ := lookupModel(req.Model); t == transportGenerate {
+		return &oneShotSession{ctx: ctx, backend: generateSynthesizer{}, req: req}, nil
+	}
+	return liveSynthesizer{}.openSession(ctx, req)
+}
@@ -0,0 +1,136 @@
+package voxlattice
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/braheezy/shine-mp3/pkg/mp3"
+)
+
+// MPEG-2 Layer III bitrates (kbps) in bitrate-index order; 24 kHz falls in MPEG-2.
+var mp3Bitrates = []int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160}
+
+func mp3BitrateIndex(kbps int) (int, error) {
+	if kbps > 0 && mp3.CheckConfig(sampleRateHz, kbps) >= 0 {
+		for i, v := range mp3Bitrates {
+			if v == kbps {
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unsupported mp3 bitrate: %d kbps, supported: %v", kbps, mp3Bitrates[1:])
+}
+
+func getDefaultMP3Bitrate() (int, error) {
+	v := strings.TrimSpace(os.Getenv("AUDIOMESH_MP3_BITRATE"))
+	if v == "" {
+		return defaultMP3BitrateKbps, nil
+	}
+	kbps, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid AUDIOMESH_MP3_BITRATE: %s", v)
+	}
+	return kbps, nil
+}
+
+func resolveMP3Bitrate(opts encodeOptions) (int, error) {
+	if opts.bitrateKbps != 0 {
+		return opts.bitrateKbps, nil
+	}
+	return getDefaultMP3Bitrate()
+}
+
+func checkMP3Options(opts encodeOptions) error {
+	kbps, err := resolveMP3Bitrate(opts)
+	if err != nil {
+		return err
+	}
+	_, err = mp3BitrateIndex(kbps)
+	return err
+}
+
+// mp3Encoder feeds shine one frame at a time. shine's Write only consumes a
+// single frame per call correctly for mono input, so partial frames are held
+// back until the next Write or padded with silence on Close.
+type mp3Encoder struct {
+	w          io.Writer
+	enc        *mp3.Encoder
+	pending    []byte
+	frameBytes int
+	samples    []int16 // one sample longer than a frame, see encodeFrame
+}
+
+func newMP3Encoder(w io.Writer, opts encodeOptions) (pcmEncoder, error) {
+	kbps, err := resolveMP3Bitrate(opts)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := mp3BitrateIndex(kbps)
+	if err != nil {
+		return nil, err
+	}
+	enc := mp3.NewEncoder(sampleRateHz, channels)
+
+	// NewEncoder always starts at 128 kbps; recompute the frame sizing it
+	// derived from that bitrate.
+	slots := float64(enc.Mpeg.GranulesPerFrame*mp3.GRANULE_SIZE) / float64(enc.Wave.SampleRate) *
+		(float64(kbps) * 1000 / float64(enc.Mpeg.BitsPerSlot))
+	enc.Mpeg.Bitrate = int64(kbps)
+	enc.Mpeg.BitrateIndex = int64(idx)
+	enc.Mpeg.WholeSlotsPerFrame = int64(slots)
+	enc.Mpeg.FracSlotsPerFrame = slots - float64(enc.Mpeg.WholeSlotsPerFrame)
+	enc.Mpeg.Slot_lag = -enc.Mpeg.FracSlotsPerFrame
+	if enc.Mpeg.FracSlotsPerFrame == 0 {
+		enc.Mpeg.Padding = 0
+	}
+
+	frameSamples := int(enc.Mpeg.GranulesPerFrame) * mp3.GRANULE_SIZE * channels
+	return &mp3Encoder{
+		w:          w,
+		enc:        enc,
+		frameBytes: frameSamples * bitsPerSample / 8,
+	}, nil
+}
+
+func (e *mp3Encoder) Write(pcm []byte) error {
+	e.pending = append(e.pending, pcm...)
+	off := 0
+	for len(e.pending)-off >= e.frameBytes {
+		if err := e.encodeFrame(e.pending[off : off+e.frameBytes]); err != nil {
+			return err
+		}
+		off += e.frameBytes
+	}
+	e.pending = append(e.pending[:0], e.pending[off:]...)
+	return nil
+}
+
+func (e *mp3Encoder) Close() error {
+	if len(e.pending) == 0 {
+		return nil
+	}
+	frame := make([]byte, e.frameBytes)
+	copy(frame, e.pending)
+	e.pending = e.pending[:0]
+	return e.encodeFrame(frame)
+}
+
+// encodeFrame hands one frame to shine, which leaves its read pointer one
+// sample past the end of the frame inside the encoder. The spare sample
+// keeps that pointer within the allocation; pointing just past it can land
+// on a free span and crash the garbage collector.
+func (e *mp3Encoder) encodeFrame(frame []byte) error {
+	n := len(frame) / 2
+	if cap(e.samples) < n+1 {
+		e.samples = make([]int16, n+1)
+	}
+	samples := e.samples[:n]
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(frame[i*2:]))
+	}
+	return e.enc.Write(e.w, samples)
+}
@@ -0,0 +1,244 @@
+package voxlattice
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand/v2"
+)
+
+const (
+	oggFlagBOS = 0x02
+	oggFlagEOS = 0x04
+
+	// Opus granule positions always count 48 kHz samples, whatever the input rate.
+	opusGranuleRate = 48000
+	opusFrameMs     = 20
+	opusMaxPacket   = 4000
+)
+
+var oggCRCTable = func() [256]uint32 {
+	var t [256]uint32
+	for i := range t {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		t[i] = r
+	}
+	return t
+}()
+
+func oggCRC(data []byte) uint32 {
+	var crc uint32
+	for _, b := range data {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+// oggWriter emits Ogg pages for a single logical stream. Packets are never
+// split across pages, which holds for Opus since packets stay well below
+// the 255*255 byte page limit.
+type oggWriter struct {
+	w       io.Writer
+	serial  uint32
+	seq     uint32
+	packets [][]byte
+	granule int64 // position after the last queued packet
+	flags   byte
+}
+
+func newOggWriter(w io.Writer) *oggWriter {
+	return &oggWriter{w: w, serial: rand.Uint32(), flags: oggFlagBOS}
+}
+
+func lacingSegments(n int) int { return n/255 + 1 }
+
+// writePacket queues a packet that ends at position granule, flushing the
+// packets before it first if it would overflow the page.
+func (o *oggWriter) writePacket(packet []byte, granule int64) error {
+	segs := 0
+	for _, p := range o.packets {
+		segs += lacingSegments(len(p))
+	}
+	if segs+lacingSegments(len(packet)) > 255 {
+		if err := o.flush(o.granule, 0); err != nil {
+			return err
+		}
+	}
+	o.packets = append(o.packets, packet)
+	o.granule = granule
+	return nil
+}
+
+// flush writes every queued packet as one page. granule is the position
+// after the last packet on the page.
+func (o *oggWriter) flush(granule int64, flags byte) error {
+	if len(o.packets) == 0 && flags&oggFlagEOS == 0 {
+		return nil
+	}
+	var lacing []byte
+	size := 0
+	for _, p := range o.packets {
+		n := len(p)
+		for n >= 255 {
+			lacing = append(lacing, 255)
+			n -= 255
+		}
+		lacing = append(lacing, byte(n))
+		size += len(p)
+	}
+
+	page := make([]byte, 27, 27+len(lacing)+size)
+	copy(page, "OggS")
+	page[5] = o.flags | flags
+	binary.LittleEndian.PutUint64(page[6:], uint64(granule))
+	binary.LittleEndian.PutUint32(page[14:], o.serial)
+	binary.LittleEndian.PutUint32(page[18:], o.seq)
+	page[26] = byte(len(lacing))
+	page = append(page, lacing...)
+	for _, p := range o.packets {
+		page = append(page, p...)
+	}
+	binary.LittleEndian.PutUint32(page[22:], oggCRC(page))
+
+	o.seq++
+	o.flags = 0
+	o.packets = o.packets[:0]
+	_, err := o.w.Write(page)
+	return err
+}
+
+// opusPacketEncoder compresses fixed-size PCM frames into Opus packets.
+type opusPacketEncoder interface {
+	Encode(frame []int16) ([]byte, error)
+	// Lookahead reports the encoder delay in input samples.
+	Lookahead() int
+	Close()
+}
+
+func resolveOpusBitrate(opts encodeOptions) int {
+	if opts.bitrateKbps != 0 {
+		return opts.bitrateKbps
+	}
+	return defaultOpusBitrateKbps
+}
+
+func checkOpusOptions(opts encodeOptions) error {
+	if !opusSupported {
+		return errOpusUnavailable
+	}
+	kbps := resolveOpusBitrate(opts)
+	if kbps < 6 || kbps > 510 {
+		return fmt.Errorf("unsupported opus bitrate: %d kbps, supported: 6-510", kbps)
+	}
+	return nil
+}
+
+// oggOpusEncoder produces an Ogg Opus stream (RFC 7845) from 24 kHz PCM.
+type oggOpusEncoder struct {
+	ogg        *oggWriter
+	enc        opusPacketEncoder
+	pending    []byte
+	frameBytes int
+	frameGran  int64
+	preSkip    int64
+	granule    int64
+	samples    int64
+}
+
+func newOggOpusEncoder(w io.Writer, opts encodeOptions) (pcmEncoder, error) {
+	if err := checkOpusOptions(opts); err != nil {
+		return nil, err
+	}
+	enc, err := newOpusPacketEncoder(sampleRateHz, channels, resolveOpusBitrate(opts)*1000)
+	if err != nil {
+		return nil, err
+	}
+	scale := int64(opusGranuleRate / sampleRateHz)
+	frameSamples := sampleRateHz * opusFrameMs / 1000
+	e := &oggOpusEncoder{
+		ogg:        newOggWriter(w),
+		enc:        enc,
+		frameBytes: frameSamples * channels * bitsPerSample / 8,
+		frameGran:  int64(frameSamples) * scale,
+		preSkip:    int64(enc.Lookahead()) * scale,
+	}
+	if err := e.writeHeaders(); err != nil {
+		enc.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *oggOpusEncoder) writeHeaders() error {
+	head := make([]byte, 19)
+	copy(head, "OpusHead")
+	head[8] = 1
+	head[9] = channels
+	binary.LittleEndian.PutUint16(head[10:], uint16(e.preSkip))
+	binary.LittleEndian.PutUint32(head[12:], sampleRateHz)
+	// Output gain and mapping family stay zero: mono/stereo, no gain.
+	e.ogg.packets = append(e.ogg.packets, head)
+	if err := e.ogg.flush(0, 0); err != nil {
+		return err
+	}
+
+	vendor := "voxlattice"
+	tags := make([]byte, 8+4+len(vendor)+4)
+	copy(tags, "OpusTags")
+	binary.LittleEndian.PutUint32(tags[8:], uint32(len(vendor)))
+	copy(tags[12:], vendor)
+	e.ogg.packets = append(e.ogg.packets, tags)
+	return e.ogg.flush(0, 0)
+}
+
+func (e *oggOpusEncoder) Write(pcm []byte) error {
+	e.samples += int64(len(pcm) / (channels * bitsPerSample / 8))
+	e.pending = append(e.pending, pcm...)
+	off := 0
+	for len(e.pending)-off >= e.frameBytes {
+		if err := e.encodeFrame(e.pending[off : off+e.frameBytes]); err != nil {
+			return err
+		}
+		off += e.frameBytes
+	}
+	e.pending = append(e.pending[:0], e.pending[off:]...)
+	// One page per write keeps latency low when streaming.
+	return e.ogg.flush(e.granule, 0)
+}
+
+func (e *oggOpusEncoder) encodeFrame(frame []byte) error {
+	packet, err := e.enc.Encode(pcmToSamples(frame))
+	if err != nil {
+		return err
+	}
+	e.granule += e.frameGran
+	return e.ogg.writePacket(packet, e.granule)
+}
+
+// Close pads the tail with enough silence to flush the encoder delay, then
+// marks the end of stream with a granule position trimmed to the real length.
+func (e *oggOpusEncoder) Close() error {
+	defer e.enc.Close()
+	tail := append(e.pending, make([]byte, e.enc.Lookahead()*channels*bitsPerSample/8)...)
+	if rem := len(tail) % e.frameBytes; rem != 0 {
+		tail = append(tail, make([]byte, e.frameBytes-rem)...)
+	}
+	for off := 0; off < len(tail); off += e.frameBytes {
+		if err := e.encodeFrame(tail[off : off+e.frameBytes]); err != nil {
+			return err
+		}
+	}
+	e.pending = e.pending[:0]
+	final := e.preSkip + e.samples*int64(opusGranuleRate/sampleRateHz)
+	if final > e.granule {
+		final = e.granule
+	}
+	return e.ogg.flush(final, oggFlagEOS)
+}
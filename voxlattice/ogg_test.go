@@ -0,0 +1,97 @@
+package voxlattice
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+type oggPage struct {
+	flags   byte
+	granule int64
+	seq     uint32
+	packets int
+}
+
+// readOggPages splits an Ogg stream into pages, checking each CRC.
+func readOggPages(t *testing.T, data []byte) []oggPage {
+	t.Helper()
+	var pages []oggPage
+	for len(data) > 0 {
+		if len(data) < 27 || string(data[:4]) != "OggS" {
+			t.Fatalf("page %d: bad capture pattern", len(pages))
+		}
+		nseg := int(data[26])
+		lacing := data[27 : 27+nseg]
+		size, packets := 0, 0
+		for _, l := range lacing {
+			size += int(l)
+			if l < 255 {
+				packets++
+			}
+		}
+		end := 27 + nseg + size
+		page := append([]byte(nil), data[:end]...)
+		want := binary.LittleEndian.Uint32(page[22:])
+		binary.LittleEndian.PutUint32(page[22:], 0)
+		if got := oggCRC(page); got != want {
+			t.Fatalf("page %d: crc %08x, header says %08x", len(pages), got, want)
+		}
+		pages = append(pages, oggPage{
+			flags:   data[5],
+			granule: int64(binary.LittleEndian.Uint64(data[6:])),
+			seq:     binary.LittleEndian.Uint32(data[18:]),
+			packets: packets,
+		})
+		data = data[end:]
+	}
+	return pages
+}
+
+func TestOggWriterPageGranules(t *testing.T) {
+	tests := []struct {
+		name       string
+		packets    int
+		packetSize int
+	}{
+		{"one page", 10, 100},
+		{"full pages", 600, 100},
+		{"multi segment packets", 300, 300},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			o := newOggWriter(&buf)
+			const frame = 960
+			for i := 1; i <= tt.packets; i++ {
+				if err := o.writePacket(make([]byte, tt.packetSize), int64(i*frame)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := o.flush(o.granule, oggFlagEOS); err != nil {
+				t.Fatal(err)
+			}
+
+			pages := readOggPages(t, buf.Bytes())
+			if len(pages) < 2 && tt.packets > 255 {
+				t.Fatalf("got %d pages, want several", len(pages))
+			}
+			seen := 0
+			for i, p := range pages {
+				seen += p.packets
+				if want := int64(seen * frame); p.granule != want {
+					t.Errorf("page %d: granule %d, want %d (end of packet %d)", i, p.granule, want, seen)
+				}
+				if p.seq != uint32(i) {
+					t.Errorf("page %d: sequence %d", i, p.seq)
+				}
+			}
+			if seen != tt.packets {
+				t.Errorf("pages hold %d packets, want %d", seen, tt.packets)
+			}
+			if pages[0].flags&oggFlagBOS == 0 || pages[len(pages)-1].flags&oggFlagEOS == 0 {
+				t.Errorf("first page flags %#x, last page flags %#x", pages[0].flags, pages[len(pages)-1].flags)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package voxlattice
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// OpenAI model names, all served by the configured Gemini model
+var openAIModels = map[string]bool{
+	"tts-1":           true,
+	"tts-1-hd":        true,
+	"gpt-4o-mini-tts": true,
+}
+
+// OpenAI voice names mapped onto the default voice set; AUDIOMESH_OPENAI_VOICES
+// ("alloy=kore,echo=charon") overrides individual entries.
+var defaultOpenAIVoices = map[string]string{
+	"alloy":   "alex",
+	"ash":     "angus",
+	"ballad":  "brian",
+	"coral":   "grace",
+	"echo":    "ethan",
+	"fable":   "emil",
+	"nova":    "olivia",
+	"onyx":    "davis",
+	"sage":    "sarah",
+	"shimmer": "seraphina",
+	"verse":   "will",
+}
+
+// OpenAI response_format values mapped to output format names
+var openAIFormats = map[string]string{
+	"mp3":  "mp3",
+	"opus": "opus",
+	"wav":  "wav",
+	"pcm":  "pcm_s16le",
+}
+
+func openAIVoiceMap() map[string]string {
+	voices := make(map[string]string, len(defaultOpenAIVoices))
+	for k, v := range defaultOpenAIVoices {
+		voices[k] = v
+	}
+	for _, pair := range strings.Split(os.Getenv("AUDIOMESH_OPENAI_VOICES"), ",") {
+		name, voice, ok := strings.Cut(pair, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		voice = strings.ToLower(strings.TrimSpace(voice))
+		if ok && name != "" && voice != "" {
+			voices[name] = voice
+		}
+	}
+	return voices
+}
+
+// resolveOpenAIVoice accepts our own voice names as well as OpenAI's.
+func resolveOpenAIVoice(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if _, exists := currentVoices()[name]; exists {
+		return name, nil
+	}
+	if voice, ok := openAIVoiceMap()[name]; ok {
+		if _, exists := currentVoices()[voice]; exists {
+			return voice, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported voice: %s, supported voices: %v", name, getSupportedVoiceNames())
+}
+
+func writeOpenAIError(w http.ResponseWriter, status int, errType, param, code, message string) {
+	e := openAIError{Message: message, Type: errType}
+	if param != "" {
+		e.Param = &param
+	}
+	if code != "" {
+		e.Code = &code
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(openAIErrorResp{Error: e})
+}
+
+func invalidOpenAIRequest(w http.ResponseWriter, param, message string) {
+	writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", param, "", message)
+}
+
+// openAISpeechHandler serves an OpenAI-compatible /v1/audio/speech so
+// existing SDKs can use Voxlattice as their base URL.
+func openAISpeechHandler(w http.ResponseWriter, r *http.Request) {
+	// Allow CORS for web access
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeOpenAIError(w, http.StatusMethodNotAllowed, "invalid_request_error", "", "", "POST only")
+		return
+	}
+
+	var in openAISpeechReq
+	body, err := io.ReadAll(r.Body)
+	if err != nil || json.Unmarshal(body, &in) != nil {
+		invalidOpenAIRequest(w, "", "invalid json")
+		return
+	}
+
+	if strings.TrimSpace(in.Model) == "" {
+		invalidOpenAIRequest(w, "model", "model is required")
+		return
+	}
+	model := getModelName()
+	if !openAIModels[strings.ToLower(strings.TrimSpace(in.Model))] {
+		if model, err = resolveModel(in.Model); err != nil {
+			invalidOpenAIRequest(w, "model", err.Error())
+			return
+		}
+	}
+
+	text, err := normalizeText(in.Input)
+	if err != nil {
+		invalidOpenAIRequest(w, "input", "input: "+err.Error())
+		return
+	}
+
+	if strings.TrimSpace(in.Voice) == "" {
+		invalidOpenAIRequest(w, "voice", "voice is required")
+		return
+	}
+	voice, err := resolveOpenAIVoice(in.Voice)
+	if err != nil {
+		invalidOpenAIRequest(w, "voice", err.Error())
+		return
+	}
+
+	responseFormat := strings.ToLower(strings.TrimSpace(in.ResponseFormat))
+	if responseFormat == "" {
+		responseFormat = "mp3"
+	}
+	formatName, ok := openAIFormats[responseFormat]
+	if !ok {
+		invalidOpenAIRequest(w, "response_format", fmt.Sprintf("unsupported response_format: %s, supported: %s", responseFormat, supportedNames(openAIFormats, "mp3", "opus", "wav", "pcm")))
+		return
+	}
+
+	speed := 1.0
+	if in.Speed != nil {
+		speed = *in.Speed
+	}
+	if speed < minSpeed || speed > maxSpeed {
+		invalidOpenAIRequest(w, "speed", fmt.Sprintf("speed must be between %g and %g", minSpeed, maxSpeed))
+		return
+	}
+
+	req := ttsReq{Text: text, Voice: voice, Format: formatName, Speed: speed}
+	format, opts, err := resolveOutput(r, req)
+	if err != nil {
+		invalidOpenAIRequest(w, "response_format", err.Error())
+		return
+	}
+
+	auth, err := resolveAPIKey(r)
+	if err == nil {
+		err = auth.checkAccess(model, voice)
+	}
+	if err != nil {
+		if status := authErrorStatus(err); status == http.StatusForbidden {
+			writeOpenAIError(w, status, "permission_error", "", "", err.Error())
+		} else {
+			writeOpenAIError(w, status, "invalid_request_error", "", "invalid_api_key", err.Error())
+		}
+		return
+	}
+	adm, err := admitRequest(r, auth, text)
+	if err != nil {
+		setRetryAfter(w, err)
+		writeOpenAIError(w, http.StatusTooManyRequests, "rate_limit_error", "", "rate_limit_exceeded", err.Error())
+		return
+	}
+	defer adm.done()
+	noteSpeech(r, voice, text)
+
+	sreq := synthRequest{Text: text, Voice: voice, Model: model, APIKey: auth.apiKey}
+	audio, err := renderSpeech(w, r, adm, req, ssmlDoc{}, sreq, format, opts, defaultChunkPauseMs)
+	var limErr *limitError
+	if errors.As(err, &limErr) {
+		setRetryAfter(w, err)
+		writeOpenAIError(w, http.StatusTooManyRequests, "rate_limit_error", "", "rate_limit_exceeded", err.Error())
+		return
+	}
+	if err != nil {
+		adm.refund()
+		if r.Context().Err() != nil {
+			reqLog(r).Debugf("tts client went away: %v", err)
+			return
+		}
+		countRequestError(r, err)
+		code := ""
+		var fidErr *fidelityError
+		if errors.As(err, &fidErr) {
+			code = fidErr.code()
+		}
+		writeOpenAIError(w, synthErrorStatus(err), "server_error", "", code, err.Error())
+		return
+	}
+	writeAudio(w, format, opts, audio)
+}
@@ -0,0 +1,91 @@
+package voxlattice
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestOpenAISpeechHandler(t *testing.T) {
+	useFakeBackend(t)
+	useVoices(t, "alex", "kore")
+
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		size        int
+		magic       string
+	}{
+		{"openai voice", `{"model":"tts-1","input":"hello","voice":"alloy","response_format":"pcm"}`, "audio/pcm", fakePCMBytes("hello"), ""},
+		{"own voice", `{"model":"gpt-4o-mini-tts","input":"hello","voice":"Kore","response_format":"wav"}`, "audio/wav", 44 + fakePCMBytes("hello"), "RIFF"},
+		{"default format", `{"model":"tts-1","input":"hello","voice":"alloy"}`, "audio/mpeg", 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(openAISpeechHandler, http.MethodPost, "/v1/audio/speech", tt.body)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, tt.contentType) {
+				t.Errorf("Content-Type %q, want %q", ct, tt.contentType)
+			}
+			if tt.size != 0 && w.Body.Len() != tt.size {
+				t.Errorf("body is %d bytes, want %d", w.Body.Len(), tt.size)
+			}
+			if w.Body.Len() == 0 || !bytes.HasPrefix(w.Body.Bytes(), []byte(tt.magic)) {
+				t.Errorf("body does not start with %q", tt.magic)
+			}
+		})
+	}
+
+	// At double speed the audio is about half as long.
+	w := serve(openAISpeechHandler, http.MethodPost, "/v1/audio/speech", `{"model":"tts-1","input":"hello","voice":"alloy","response_format":"pcm","speed":2}`)
+	if got, want := w.Body.Len(), fakePCMBytes("hello")/2; got < want*9/10 || got > want*11/10 {
+		t.Errorf("speed 2 gives %d bytes, want about %d", got, want)
+	}
+}
+
+func TestOpenAISpeechHandlerErrors(t *testing.T) {
+	useFakeBackend(t)
+	useVoices(t, "alex")
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+		param  string
+	}{
+		{"method", http.MethodGet, "", http.StatusMethodNotAllowed, ""},
+		{"bad json", http.MethodPost, `{"model":`, http.StatusBadRequest, ""},
+		{"no model", http.MethodPost, `{"input":"hi","voice":"alloy"}`, http.StatusBadRequest, "model"},
+		{"unknown model", http.MethodPost, `{"model":"whisper-1","input":"hi","voice":"alloy"}`, http.StatusBadRequest, "model"},
+		{"no input", http.MethodPost, `{"model":"tts-1","input":" ","voice":"alloy"}`, http.StatusBadRequest, "input"},
+		{"no voice", http.MethodPost, `{"model":"tts-1","input":"hi"}`, http.StatusBadRequest, "voice"},
+		{"unknown voice", http.MethodPost, `{"model":"tts-1","input":"hi","voice":"nova"}`, http.StatusBadRequest, "voice"},
+		{"format", http.MethodPost, `{"model":"tts-1","input":"hi","voice":"alloy","response_format":"flac"}`, http.StatusBadRequest, "response_format"},
+		{"speed", http.MethodPost, `{"model":"tts-1","input":"hi","voice":"alloy","speed":5}`, http.StatusBadRequest, "speed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(openAISpeechHandler, tt.method, "/v1/audio/speech", tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			var resp openAIErrorResp
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Error.Message == "" {
+				t.Fatalf("body %s is not an OpenAI error: %v", w.Body, err)
+			}
+			param := ""
+			if resp.Error.Param != nil {
+				param = *resp.Error.Param
+			}
+			if param != tt.param {
+				t.Errorf("param %q, want %q", param, tt.param)
+			}
+		})
+	}
+}
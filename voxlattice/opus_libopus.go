@@ -0,0 +1,83 @@
+//go:build cgo && opus
+
+package voxlattice
+
+/*
+#cgo pkg-config: opus
+#include <opus/opus.h>
+
+static int vl_opus_set_bitrate(OpusEncoder *enc, opus_int32 bitrate) {
+	return opus_encoder_ctl(enc, OPUS_SET_BITRATE(bitrate));
+}
+
+static int vl_opus_get_lookahead(OpusEncoder *enc, opus_int32 *out) {
+	return opus_encoder_ctl(enc, OPUS_GET_LOOKAHEAD(out));
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+const opusSupported = true
+
+var errOpusUnavailable error
+
+type libopusEncoder struct {
+	enc       *C.OpusEncoder
+	lookahead int
+	buf       []byte
+}
+
+func opusError(op string, code C.int) error {
+	return fmt.Errorf("%s: %s", op, C.GoString(C.opus_strerror(code)))
+}
+
+func newOpusPacketEncoder(sampleRate, channels, bitrate int) (opusPacketEncoder, error) {
+	var code C.int
+	enc := C.opus_encoder_create(C.opus_int32(sampleRate), C.int(channels), C.OPUS_APPLICATION_AUDIO, &code)
+	if code != C.OPUS_OK {
+		return nil, opusError("opus encoder create", code)
+	}
+	if code = C.vl_opus_set_bitrate(enc, C.opus_int32(bitrate)); code != C.OPUS_OK {
+		C.opus_encoder_destroy(enc)
+		return nil, opusError("opus set bitrate", code)
+	}
+	var lookahead C.opus_int32
+	if code = C.vl_opus_get_lookahead(enc, &lookahead); code != C.OPUS_OK {
+		C.opus_encoder_destroy(enc)
+		return nil, opusError("opus get lookahead", code)
+	}
+	return &libopusEncoder{
+		enc:       enc,
+		lookahead: int(lookahead),
+		buf:       make([]byte, opusMaxPacket),
+	}, nil
+}
+
+func (e *libopusEncoder) Encode(frame []int16) ([]byte, error) {
+	n := C.opus_encode(
+		e.enc,
+		(*C.opus_int16)(unsafe.Pointer(&frame[0])),
+		C.int(len(frame)/channels),
+		(*C.uchar)(unsafe.Pointer(&e.buf[0])),
+		C.opus_int32(len(e.buf)),
+	)
+	if n < 0 {
+		return nil, opusError("opus encode", n)
+	}
+	packet := make([]byte, int(n))
+	copy(packet, e.buf[:n])
+	return packet, nil
+}
+
+func (e *libopusEncoder) Lookahead() int { return e.lookahead }
+
+func (e *libopusEncoder) Close() {
+	if e.enc != nil {
+		C.opus_encoder_destroy(e.enc)
+		e.enc = nil
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !(cgo && opus)
+
+package voxlattice
+
+import "errors"
+
+const opusSupported = false
+
+var errOpusUnavailable = errors.New("opus output is not available in this build (rebuild with CGO_ENABLED=1 -tags opus and libopus installed)")
+
+func newOpusPacketEncoder(sampleRate, channels, bitrate int) (opusPacketEncoder, error) {
+	return nil, errOpusUnavailable
+}
@@ -0,0 +1,164 @@
+package voxlattice
+
+import "math"
+
+const (
+	// Zero crossings of the sinc kept on each side of the filter centre.
+	resampleZeroCrossings = 16
+	// Cutoff relative to the lower Nyquist frequency; the gap is the
+	// transition band that keeps aliasing out of the passband.
+	resampleRolloff    = 0.92
+	resampleKaiserBeta = 8.6
+	// Above this many phases the taps are computed per sample instead of
+	// being tabulated.
+	resampleMaxTablePhases = 2048
+)
+
+// resampler converts mono 16-bit audio between arbitrary rates with a
+// Kaiser-windowed sinc low-pass filter, so downsampling is properly
+// band-limited rather than dropping samples. It keeps just enough history
+// to be fed in chunks.
+type resampler struct {
+	up, down int64 // output/input rate ratio reduced to lowest terms
+	half     int64 // filter taps on each side of the centre, in input samples
+	cutoff   float64
+	table    [][]float64 // per-phase taps, nil when computed on the fly
+
+	buf     []float64 // pending input; buf[0] is input sample base
+	base    int64
+	inCount int64
+	next    int64 // index of the next output sample
+}
+
+func newResampler(from, to int) *resampler {
+	g := gcd(from, to)
+	r := &resampler{up: int64(to / g), down: int64(from / g)}
+	r.cutoff = resampleRolloff * math.Min(1, float64(r.up)/float64(r.down))
+	r.half = int64(math.Ceil(resampleZeroCrossings / r.cutoff))
+	if r.up <= resampleMaxTablePhases {
+		r.table = make([][]float64, r.up)
+		for p := range r.table {
+			r.table[p] = r.taps(int64(p))
+		}
+	}
+	return r
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// taps computes the normalised filter for an output sample whose position
+// falls phase/up of the way past an input sample.
+func (r *resampler) taps(phase int64) []float64 {
+	frac := float64(phase) / float64(r.up)
+	out := make([]float64, 2*r.half)
+	sum := 0.0
+	for j := range out {
+		t := frac + float64(r.half-1-int64(j))
+		x := t / float64(r.half)
+		if x < -1 || x > 1 {
+			continue
+		}
+		w := besselI0(resampleKaiserBeta*math.Sqrt(1-x*x)) / besselI0(resampleKaiserBeta)
+		out[j] = r.cutoff * sinc(r.cutoff*t) * w
+		sum += out[j]
+	}
+	// Normalise for unity gain at DC.
+	if sum != 0 {
+		for j := range out {
+			out[j] /= sum
+		}
+	}
+	return out
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// besselI0 is the zeroth-order modified Bessel function of the first kind.
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; k < 50; k++ {
+		term *= (x / (2 * float64(k))) * (x / (2 * float64(k)))
+		sum += term
+		if term < sum*1e-12 {
+			break
+		}
+	}
+	return sum
+}
+
+// process consumes in and returns every output sample that can be computed.
+// With final set, the remaining input is treated as followed by silence and
+// the output is completed to the exact resampled length.
+func (r *resampler) process(in []int16, final bool) []int16 {
+	for _, v := range in {
+		r.buf = append(r.buf, float64(v))
+	}
+	r.inCount += int64(len(in))
+
+	limit := (r.inCount*r.up + r.down - 1) / r.down
+	var out []int16
+	for r.next < limit {
+		pos := r.next * r.down
+		center := pos / r.up
+		if !final && center+r.half >= r.inCount {
+			break
+		}
+		var coeffs []float64
+		if r.table != nil {
+			coeffs = r.table[pos%r.up]
+		} else {
+			coeffs = r.taps(pos % r.up)
+		}
+		first := center - r.half + 1
+		acc := 0.0
+		for j, c := range coeffs {
+			k := first + int64(j)
+			if k < 0 || k >= r.inCount {
+				continue
+			}
+			acc += c * r.buf[k-r.base]
+		}
+		out = append(out, clampSample(acc))
+		r.next++
+	}
+
+	// Drop input that no future output sample can reach.
+	keepFrom := (r.next*r.down)/r.up - r.half + 1
+	if drop := keepFrom - r.base; drop > 0 {
+		if drop > int64(len(r.buf)) {
+			drop = int64(len(r.buf))
+		}
+		r.buf = append(r.buf[:0], r.buf[drop:]...)
+		r.base += drop
+	}
+	return out
+}
+
+func clampSample(v float64) int16 {
+	v = math.Round(v)
+	if v > math.MaxInt16 {
+		return math.MaxInt16
+	}
+	if v < math.MinInt16 {
+		return math.MinInt16
+	}
+	return int16(v)
+}
+
+// resamplePCM converts a complete 16-bit PCM buffer between rates.
+func resamplePCM(pcm []byte, from, to int) []byte {
+	if from == to {
+		return pcm
+	}
+	return samplesToPCM(newResampler(from, to).process(pcmToSamples(pcm), true))
+}
@@ -0,0 +1,86 @@
+package voxlattice
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+// sineSamples is n samples of a sine at freq Hz sampled at rate Hz.
+func sineSamples(n, rate int, freq, amplitude float64) []int16 {
+	out := make([]int16, n)
+	for i := range out {
+		out[i] = int16(amplitude * math.Sin(2*math.Pi*freq*float64(i)/float64(rate)))
+	}
+	return out
+}
+
+// rms is the root mean square of samples, skipping edge samples at each end
+// where the filter runs into silence.
+func rms(samples []int16, edge int) float64 {
+	samples = samples[edge : len(samples)-edge]
+	sum := 0.0
+	for _, v := range samples {
+		sum += float64(v) * float64(v)
+	}
+	return math.Sqrt(sum / float64(len(samples)))
+}
+
+func TestResamplerLength(t *testing.T) {
+	for _, to := range []int{8000, 16000, 22050, 44100, 48000, 96000} {
+		for _, n := range []int{0, 1, 7, 2400, 24001} {
+			got := len(newResampler(sampleRateHz, to).process(make([]int16, n), true))
+			want := (n*to + sampleRateHz - 1) / sampleRateHz
+			if got != want {
+				t.Errorf("%d samples at %d Hz: %d samples at %d Hz, want %d", n, sampleRateHz, got, to, want)
+			}
+		}
+	}
+	if pcm := []byte{1, 2, 3, 4}; &resamplePCM(pcm, 8000, 8000)[0] != &pcm[0] {
+		t.Error("resamplePCM copied audio at the same rate")
+	}
+}
+
+func TestResamplerChunked(t *testing.T) {
+	in := sineSamples(24000, sampleRateHz, 440, 10000)
+	for _, to := range []int{8000, 22050} {
+		whole := newResampler(sampleRateHz, to).process(in, true)
+		r := newResampler(sampleRateHz, to)
+		var chunked []int16
+		for rest := in; len(rest) > 0; {
+			n := min(len(rest), 997)
+			chunked = append(chunked, r.process(rest[:n], false)...)
+			rest = rest[n:]
+		}
+		chunked = append(chunked, r.process(nil, true)...)
+		if !slices.Equal(whole, chunked) {
+			t.Errorf("%d Hz: chunked output differs from one buffer (%d vs %d samples)", to, len(chunked), len(whole))
+		}
+	}
+}
+
+func TestResamplerFiltersAboveNyquist(t *testing.T) {
+	const amplitude = 10000
+	tests := []struct {
+		to     int
+		freq   float64
+		passes bool
+	}{
+		{8000, 1000, true},
+		{8000, 3000, true},
+		{8000, 6000, false}, // would alias to 2 kHz
+		{8000, 5000, false},
+		{16000, 6000, true},
+		{16000, 10000, false},
+	}
+	for _, tt := range tests {
+		out := newResampler(sampleRateHz, tt.to).process(sineSamples(sampleRateHz, sampleRateHz, tt.freq, amplitude), true)
+		ratio := rms(out, tt.to/50) / (amplitude / math.Sqrt2)
+		if tt.passes && (ratio < 0.95 || ratio > 1.05) {
+			t.Errorf("%v Hz to %d Hz: level %.3f, want about 1", tt.freq, tt.to, ratio)
+		}
+		if !tt.passes && ratio > 0.01 {
+			t.Errorf("%v Hz to %d Hz: level %.4f, want below 0.01 (-40 dB)", tt.freq, tt.to, ratio)
+		}
+	}
+}
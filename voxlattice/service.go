@@ -2,6 +2,7 @@ package voxlattice
 
 import (
 	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"os/exec"
@@ -129,7 +130,24 @@ func buildSystemdService(execPath, workingDir, envPath string, args []string) st
 	return b.String()
 }
 
-func buildServiceArgs(logPath, logLevel, configDir string) []string {
+// serviceFlagArgs returns the explicitly set command line flags that the
+// installed service should also be started with. Flags that only control the
+// installation itself or are handled by buildServiceArgs are skipped.
+func serviceFlagArgs() []string {
+	skip := map[string]bool{
+		"install": true, "uninstall": true, "service-name": true, "env": true,
+		"log": true, "log-level": true, "config": true, "add-key": true,
+	}
+	var args []string
+	flag.Visit(func(f *flag.Flag) {
+		if !skip[f.Name] {
+			args = append(args, "--"+f.Name+"="+f.Value.String())
+		}
+	})
+	return args
+}
+
+func buildServiceArgs(logPath, logLevel, configDir string, extra []string) []string {
 	args := []string{}
 	if logPath != "" {
 		args = append(args, "--log", logPath)
@@ -140,7 +158,7 @@ func buildServiceArgs(logPath, logLevel, configDir string) []string {
 	if configDir != "" && configDir != "." {
 		args = append(args, "--config", configDir)
 	}
-	return args
+	return append(args, extra...)
 }
 
 func installLinuxService(serviceName, envPath string, args []string) error {
@@ -0,0 +1,535 @@
+package voxlattice
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	maxBreakMs = 10000
+	// Per document, so breaks cannot ask for more silence than a request
+	// may take, nor split the text into endless upstream calls
+	maxSSMLSilenceMs = int(maxRequestTimeout / time.Millisecond)
+	maxSSMLParts     = 200
+)
+
+// Pauses for <break strength>, in ms
+var breakStrengths = map[string]int{
+	"none":     0,
+	"x-weak":   100,
+	"weak":     250,
+	"medium":   400,
+	"strong":   750,
+	"x-strong": 1200,
+}
+
+// Delivery instructions for named <prosody> and <emphasis> values
+var (
+	prosodyRates = map[string]string{
+		"x-slow": "very slowly",
+		"slow":   "slowly",
+		"medium": "",
+		"fast":   "quickly",
+		"x-fast": "very quickly",
+	}
+	prosodyPitches = map[string]string{
+		"x-low":  "in a very low voice",
+		"low":    "in a low voice",
+		"medium": "",
+		"high":   "in a high voice",
+		"x-high": "in a very high voice",
+	}
+	prosodyVolumes = map[string]string{
+		"silent": "in a whisper",
+		"x-soft": "very softly",
+		"soft":   "softly",
+		"medium": "",
+		"loud":   "loudly",
+		"x-loud": "very loudly",
+	}
+	emphasisLevels = map[string]string{
+		"strong":   "with strong emphasis",
+		"moderate": "with emphasis",
+		"reduced":  "with reduced emphasis",
+		"none":     "",
+	}
+)
+
+// ssmlError marks a problem with the caller's SSML, reported as a 400.
+type ssmlError struct{ msg string }
+
+func (e *ssmlError) Error() string { return "invalid ssml: " + e.msg }
+
+func ssmlErrorf(format string, args ...any) error {
+	return &ssmlError{msg: fmt.Sprintf(format, args...)}
+}
+
+// ssmlRun is a stretch of text spoken with one style and language.
+type ssmlRun struct {
+	text    string
+	style   string
+	lang    string
+	pauseMs int // break before the run
+}
+
+// ssmlDoc is a parsed <speak> document: runs of text separated by breaks.
+type ssmlDoc struct {
+	runs       []ssmlRun
+	leadingMs  int // breaks before the first run
+	trailingMs int // breaks after the last run
+}
+
+// text returns the plain text of the document, for length checks and logs.
+func (d ssmlDoc) text() string {
+	var b strings.Builder
+	for i, run := range d.runs {
+		if i > 0 {
+			b.WriteString(" ")
+		}
+		b.WriteString(run.text)
+	}
+	return b.String()
+}
+
+// plan splits the runs into backend-sized parts. Chunks inside one run are
+// separated by pauseMs like plain text.
+func (d ssmlDoc) plan(pauseMs int) speechPlan {
+	plan := speechPlan{leadingMs: d.leadingMs, trailingMs: d.trailingMs}
+	for _, run := range d.runs {
+		for i, chunk := range splitText(run.text, maxChunkLen) {
+			part := speechPart{text: chunk, style: run.style, lang: run.lang, pauseMs: pauseMs}
+			if i == 0 {
+				part.pauseMs = run.pauseMs
+			}
+			plan.parts = append(plan.parts, part)
+		}
+	}
+	return plan
+}
+
+// ssmlScope is the delivery in effect inside an element.
+type ssmlScope struct {
+	styles []string
+	lang   string
+}
+
+type ssmlParser struct {
+	dec     *xml.Decoder
+	doc     ssmlDoc
+	cur     *ssmlRun
+	text    strings.Builder // text of cur
+	pending int             // break ms since the last text
+	broken  bool            // a <break> was seen since the last text
+	silence int             // break ms in the whole document
+}
+
+// parseSSML parses a <speak> document. Breaks become silence, <sub> and
+// <say-as> rewrite the text, <prosody> and <emphasis> become delivery
+// instructions and <lang> switches the language of its content. Anything
+// else is rejected with an *ssmlError.
+func parseSSML(input string) (ssmlDoc, error) {
+	p := &ssmlParser{dec: xml.NewDecoder(strings.NewReader(input))}
+	p.dec.Strict = true
+
+	var stack []ssmlScope
+	seenRoot := false
+	for {
+		tok, err := p.dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return ssmlDoc{}, ssmlErrorf("%v", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := t.Name.Local
+			if len(stack) == 0 {
+				if seenRoot || name != "speak" {
+					return ssmlDoc{}, ssmlErrorf("document must have a single <speak> root")
+				}
+				seenRoot = true
+				stack = append(stack, ssmlScope{lang: attr(t, "lang")})
+				continue
+			}
+			scope := stack[len(stack)-1]
+			scope.styles = append([]string(nil), scope.styles...)
+
+			switch name {
+			case "p", "s":
+				p.separate(name)
+			case "break":
+				ms, err := breakDuration(t)
+				if err == nil {
+					err = p.addBreak(ms)
+				}
+				if err != nil {
+					return ssmlDoc{}, err
+				}
+			case "sub":
+				alias, ok := attrOK(t, "alias")
+				if !ok {
+					return ssmlDoc{}, ssmlErrorf("<sub> requires an alias attribute")
+				}
+				if _, err := p.innerText(name); err != nil {
+					return ssmlDoc{}, err
+				}
+				p.addText(alias, scope)
+				continue
+			case "say-as":
+				inner, err := p.innerText(name)
+				if err != nil {
+					return ssmlDoc{}, err
+				}
+				text, err := sayAs(attr(t, "interpret-as"), inner)
+				if err != nil {
+					return ssmlDoc{}, err
+				}
+				p.addText(text, scope)
+				continue
+			case "emphasis":
+				level := attr(t, "level")
+				if level == "" {
+					level = "moderate"
+				}
+				style, ok := emphasisLevels[level]
+				if !ok {
+					return ssmlDoc{}, ssmlErrorf("unsupported emphasis level: %s", level)
+				}
+				scope.styles = appendStyle(scope.styles, style)
+			case "prosody":
+				styles, err := prosodyStyles(t)
+				if err != nil {
+					return ssmlDoc{}, err
+				}
+				scope.styles = append(scope.styles, styles...)
+			case "lang":
+				lang := attr(t, "lang")
+				if lang == "" {
+					return ssmlDoc{}, ssmlErrorf("<lang> requires an xml:lang attribute")
+				}
+				scope.lang = lang
+			case "mark":
+				// Accepted for compatibility; marks carry no audio.
+			default:
+				return ssmlDoc{}, ssmlErrorf("unsupported tag: <%s>", name)
+			}
+			stack = append(stack, scope)
+
+		case xml.EndElement:
+			if len(stack) == 0 {
+				continue
+			}
+			if name := t.Name.Local; name == "p" || name == "s" {
+				p.separate(name)
+			}
+			stack = stack[:len(stack)-1]
+
+		case xml.CharData:
+			if len(stack) == 0 {
+				if strings.TrimSpace(string(t)) != "" {
+					return ssmlDoc{}, ssmlErrorf("text outside <speak>")
+				}
+				continue
+			}
+			p.addText(string(t), stack[len(stack)-1])
+		}
+		if len(p.doc.runs) > maxSSMLParts {
+			return ssmlDoc{}, ssmlErrorf("document is split into more than %d parts", maxSSMLParts)
+		}
+	}
+	if !seenRoot {
+		return ssmlDoc{}, ssmlErrorf("document must have a single <speak> root")
+	}
+
+	p.flush()
+	if p.broken {
+		if len(p.doc.runs) == 0 {
+			p.doc.leadingMs += p.pending
+		} else {
+			p.doc.trailingMs = p.pending
+		}
+	}
+	if len(p.doc.runs) == 0 {
+		return ssmlDoc{}, errors.New("text is empty")
+	}
+	parts := 0
+	for _, run := range p.doc.runs {
+		parts += len(splitText(run.text, maxChunkLen))
+	}
+	if parts > maxSSMLParts {
+		return ssmlDoc{}, ssmlErrorf("document is split into more than %d parts", maxSSMLParts)
+	}
+	return p.doc, nil
+}
+
+// addText appends text spoken with scope's delivery, starting a new run
+// after a break or when the delivery changes.
+func (p *ssmlParser) addText(text string, scope ssmlScope) {
+	style := strings.Join(scope.styles, ", ")
+	if strings.TrimSpace(text) == "" {
+		if p.cur != nil && !p.broken {
+			p.text.WriteString(text)
+		}
+		return
+	}
+	if p.cur != nil && !p.broken && p.cur.style == style && p.cur.lang == scope.lang {
+		p.text.WriteString(text)
+		return
+	}
+	p.flush()
+	pause := 0
+	if p.broken {
+		if len(p.doc.runs) == 0 {
+			p.doc.leadingMs += p.pending
+		} else {
+			pause = p.pending
+		}
+	}
+	p.pending, p.broken = 0, false
+	p.cur = &ssmlRun{style: style, lang: scope.lang, pauseMs: pause}
+	p.text.WriteString(text)
+}
+
+// flush closes the current run, dropping it when nothing speakable is left
+// and carrying its break over to the next run.
+func (p *ssmlParser) flush() {
+	if p.cur == nil {
+		return
+	}
+	run := *p.cur
+	p.cur = nil
+	text, err := normalizeText(collapseSpace(p.text.String()))
+	p.text.Reset()
+	if err != nil {
+		p.pending += run.pauseMs
+		p.broken = p.broken || run.pauseMs > 0
+		return
+	}
+	run.text = text
+	p.doc.runs = append(p.doc.runs, run)
+}
+
+// separate marks a paragraph or sentence boundary in the current run so
+// chunking still sees the document structure.
+func (p *ssmlParser) separate(name string) {
+	if p.cur == nil || p.broken {
+		return
+	}
+	if name == "p" {
+		p.text.WriteString("\n\n")
+	} else {
+		p.text.WriteString(" ")
+	}
+}
+
+func (p *ssmlParser) addBreak(ms int) error {
+	p.silence += ms
+	if p.silence > maxSSMLSilenceMs {
+		return ssmlErrorf("breaks add up to more than %ds of silence", maxSSMLSilenceMs/1000)
+	}
+	p.pending += ms
+	p.broken = true
+	return nil
+}
+
+// innerText reads the content of the element just opened up to its end
+// tag. Nested elements are not allowed.
+func (p *ssmlParser) innerText(name string) (string, error) {
+	var b strings.Builder
+	for {
+		tok, err := p.dec.Token()
+		if err != nil {
+			return "", ssmlErrorf("%v", err)
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			b.Write(t)
+		case xml.EndElement:
+			return strings.TrimSpace(b.String()), nil
+		case xml.StartElement:
+			return "", ssmlErrorf("<%s> cannot contain <%s>", name, t.Name.Local)
+		}
+	}
+}
+
+// collapseSpace folds runs of spaces and single line breaks into one space
+// while keeping paragraph breaks.
+func collapseSpace(s string) string {
+	paras := strings.Split(s, "\n\n")
+	out := paras[:0]
+	for _, para := range paras {
+		if para = strings.Join(strings.Fields(para), " "); para != "" {
+			out = append(out, para)
+		}
+	}
+	return strings.Join(out, "\n\n")
+}
+
+func attr(el xml.StartElement, name string) string {
+	v, _ := attrOK(el, name)
+	return v
+}
+
+func attrOK(el xml.StartElement, name string) (string, bool) {
+	for _, a := range el.Attr {
+		if a.Name.Local == name {
+			return strings.TrimSpace(a.Value), true
+		}
+	}
+	return "", false
+}
+
+func appendStyle(styles []string, style string) []string {
+	if style == "" {
+		return styles
+	}
+	return append(styles, style)
+}
+
+func breakDuration(el xml.StartElement) (int, error) {
+	if v, ok := attrOK(el, "time"); ok {
+		var ms float64
+		var err error
+		switch {
+		case strings.HasSuffix(v, "ms"):
+			ms, err = strconv.ParseFloat(strings.TrimSuffix(v, "ms"), 64)
+		case strings.HasSuffix(v, "s"):
+			ms, err = strconv.ParseFloat(strings.TrimSuffix(v, "s"), 64)
+			ms *= 1000
+		default:
+			err = errors.New("missing unit")
+		}
+		if err != nil || ms < 0 {
+			return 0, ssmlErrorf("invalid break time: %q", v)
+		}
+		if ms > maxBreakMs {
+			return 0, ssmlErrorf("break time %q exceeds %dms", v, maxBreakMs)
+		}
+		return int(ms), nil
+	}
+	strength := attr(el, "strength")
+	if strength == "" {
+		strength = "medium"
+	}
+	ms, ok := breakStrengths[strength]
+	if !ok {
+		return 0, ssmlErrorf("unsupported break strength: %s", strength)
+	}
+	return ms, nil
+}
+
+// prosodyStyles turns rate, pitch and volume into delivery instructions.
+// Relative values ("80%", "+2st", "-6dB") are described as such.
+func prosodyStyles(el xml.StartElement) ([]string, error) {
+	var styles []string
+	for _, a := range el.Attr {
+		v := strings.TrimSpace(a.Value)
+		var style string
+		var ok bool
+		switch a.Name.Local {
+		case "rate":
+			if style, ok = prosodyRates[v]; !ok {
+				if pct, err := strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64); err == nil && strings.HasSuffix(v, "%") && pct > 0 {
+					style, ok = fmt.Sprintf("at %g%% of the normal speaking rate", pct), true
+				}
+			}
+		case "pitch":
+			if style, ok = prosodyPitches[v]; !ok && relativeValue(v, "st", "Hz", "%") {
+				style, ok = fmt.Sprintf("with the pitch changed by %s", v), true
+			}
+		case "volume":
+			if style, ok = prosodyVolumes[v]; !ok && relativeValue(v, "dB") {
+				style, ok = fmt.Sprintf("with the volume changed by %s", v), true
+			}
+		default:
+			return nil, ssmlErrorf("unsupported prosody attribute: %s", a.Name.Local)
+		}
+		if !ok {
+			return nil, ssmlErrorf("unsupported prosody %s: %q", a.Name.Local, v)
+		}
+		styles = appendStyle(styles, style)
+	}
+	return styles, nil
+}
+
+// relativeValue reports whether v is a signed number with one of units.
+func relativeValue(v string, units ...string) bool {
+	if !strings.HasPrefix(v, "+") && !strings.HasPrefix(v, "-") {
+		return false
+	}
+	for _, unit := range units {
+		if num, ok := strings.CutSuffix(v, unit); ok {
+			_, err := strconv.ParseFloat(num, 64)
+			return err == nil
+		}
+	}
+	return false
+}
+
+// sayAs rewrites text according to interpret-as so the model reads it the
+// intended way. Kinds the model already reads well pass through unchanged.
+func sayAs(kind, text string) (string, error) {
+	switch kind {
+	case "characters", "spell-out", "verbatim":
+		return spaced(text, func(r rune) bool { return !unicode.IsSpace(r) }), nil
+	case "digits":
+		return spaced(text, unicode.IsDigit), nil
+	case "telephone":
+		var groups []string
+		for _, g := range strings.FieldsFunc(text, func(r rune) bool { return !unicode.IsDigit(r) && r != '+' }) {
+			groups = append(groups, spaced(g, unicode.IsDigit))
+		}
+		return strings.Join(groups, ", "), nil
+	case "ordinal":
+		return ordinal(text), nil
+	case "cardinal", "number":
+		return strings.ReplaceAll(text, ",", ""), nil
+	case "expletive", "bleep":
+		return "beep", nil
+	case "date", "time", "currency", "unit", "fraction", "address":
+		return text, nil
+	case "":
+		return "", ssmlErrorf("<say-as> requires an interpret-as attribute")
+	}
+	return "", ssmlErrorf("unsupported say-as interpret-as: %s", kind)
+}
+
+// spaced separates the runes of text that keep matches with spaces, so
+// "ABC" is read letter by letter. Other runes are dropped.
+func spaced(text string, keep func(rune) bool) string {
+	var out []string
+	for _, r := range text {
+		if keep(r) {
+			out = append(out, string(r))
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+// ordinal adds an English ordinal suffix to a plain integer ("21" -> "21st").
+func ordinal(text string) string {
+	n, err := strconv.Atoi(text)
+	if err != nil || n < 0 {
+		return text
+	}
+	suffix := "th"
+	if n%100 < 11 || n%100 > 13 {
+		switch n % 10 {
+		case 1:
+			suffix = "st"
+		case 2:
+			suffix = "nd"
+		case 3:
+			suffix = "rd"
+		}
+	}
+	return text + suffix
+}
@@ -0,0 +1,214 @@
+package voxlattice
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSSML(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  ssmlDoc
+	}{
+		{
+			name:  "plain",
+			input: "<speak>Hello   world</speak>",
+			want:  ssmlDoc{runs: []ssmlRun{{text: "Hello world"}}},
+		},
+		{
+			name:  "break time",
+			input: `<speak>One<break time="1.5s"/>two<break time="300ms"/>three</speak>`,
+			want: ssmlDoc{runs: []ssmlRun{
+				{text: "One"},
+				{text: "two", pauseMs: 1500},
+				{text: "three", pauseMs: 300},
+			}},
+		},
+		{
+			name:  "break strength",
+			input: `<speak>One<break/>two<break strength="x-strong"/>three</speak>`,
+			want: ssmlDoc{runs: []ssmlRun{
+				{text: "One"},
+				{text: "two", pauseMs: 400},
+				{text: "three", pauseMs: 1200},
+			}},
+		},
+		{
+			name:  "leading and trailing breaks",
+			input: `<speak><break time="200ms"/><break time="100ms"/>Hi<break time="1s"/></speak>`,
+			want:  ssmlDoc{runs: []ssmlRun{{text: "Hi"}}, leadingMs: 300, trailingMs: 1000},
+		},
+		{
+			name:  "sub",
+			input: `<speak>Read <sub alias="World Wide Web">WWW</sub> aloud</speak>`,
+			want:  ssmlDoc{runs: []ssmlRun{{text: "Read World Wide Web aloud"}}},
+		},
+		{
+			name:  "say-as",
+			input: `<speak>Code <say-as interpret-as="characters">AB1</say-as>, the <say-as interpret-as="ordinal">22</say-as></speak>`,
+			want:  ssmlDoc{runs: []ssmlRun{{text: "Code A B 1, the 22nd"}}},
+		},
+		{
+			name:  "prosody and emphasis",
+			input: `<speak>Normal <prosody rate="slow" volume="+6dB">slow <emphasis>this</emphasis></prosody> normal</speak>`,
+			want: ssmlDoc{runs: []ssmlRun{
+				{text: "Normal"},
+				{text: "slow", style: "slowly, with the volume changed by +6dB"},
+				{text: "this", style: "slowly, with the volume changed by +6dB, with emphasis"},
+				{text: "normal"},
+			}},
+		},
+		{
+			name:  "lang",
+			input: `<speak xml:lang="en-US">Hello <lang xml:lang="fr-FR">bonjour</lang></speak>`,
+			want: ssmlDoc{runs: []ssmlRun{
+				{text: "Hello", lang: "en-US"},
+				{text: "bonjour", lang: "fr-FR"},
+			}},
+		},
+		{
+			name:  "paragraphs",
+			input: "<speak><p>First one.</p><p>Second\n one.</p></speak>",
+			want:  ssmlDoc{runs: []ssmlRun{{text: "First one.\n\nSecond one."}}},
+		},
+		{
+			name:  "mark",
+			input: `<speak>Before <mark name="m1"/>after</speak>`,
+			want:  ssmlDoc{runs: []ssmlRun{{text: "Before after"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSSML(tt.input)
+			if err != nil {
+				t.Fatalf("parseSSML: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSSML(%q) = %+v, want %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSSMLErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"no root", "Hello"},
+		{"wrong root", "<voice>Hello</voice>"},
+		{"two roots", "<speak>a</speak><speak>b</speak>"},
+		{"text outside", "<speak>a</speak>b"},
+		{"unclosed", "<speak>Hello"},
+		{"unknown tag", "<speak><audio src='x'/>Hi</speak>"},
+		{"break without unit", `<speak>a<break time="5"/>b</speak>`},
+		{"break too long", `<speak>a<break time="11s"/>b</speak>`},
+		{"negative break", `<speak>a<break time="-1s"/>b</speak>`},
+		{"bad strength", `<speak>a<break strength="huge"/>b</speak>`},
+		{"sub without alias", "<speak><sub>x</sub></speak>"},
+		{"nested sub", `<speak><sub alias="a"><break/></sub></speak>`},
+		{"say-as without kind", "<speak><say-as>1</say-as></speak>"},
+		{"bad say-as", `<speak><say-as interpret-as="morse">1</say-as></speak>`},
+		{"bad emphasis", `<speak><emphasis level="loud">x</emphasis></speak>`},
+		{"bad prosody value", `<speak><prosody rate="warp">x</prosody></speak>`},
+		{"bad prosody attribute", `<speak><prosody duration="2s">x</prosody></speak>`},
+		{"lang without lang", "<speak><lang>x</lang></speak>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseSSML(tt.input)
+			var ssmlErr *ssmlError
+			if !errors.As(err, &ssmlErr) {
+				t.Errorf("parseSSML(%q) error = %v, want an *ssmlError", tt.input, err)
+			}
+		})
+	}
+}
+
+func TestParseSSMLLimits(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		ok    bool
+	}{
+		{"silence at the cap", "<speak>a" + strings.Repeat(`<break time="10s"/>`, 60) + "b</speak>", true},
+		{"silence over the cap", "<speak>a" + strings.Repeat(`<break time="10s"/>`, 61) + "b</speak>", false},
+		{"leading silence over the cap", "<speak>" + strings.Repeat(`<break time="10s"/>`, 10000) + "a</speak>", false},
+		{"runs at the cap", "<speak>" + strings.Repeat(`w<break time="1ms"/>`, maxSSMLParts) + "</speak>", true},
+		{"runs over the cap", "<speak>" + strings.Repeat(`w<break time="1ms"/>`, maxSSMLParts+1) + "</speak>", false},
+		{"style runs over the cap", "<speak>" + strings.Repeat(`a <emphasis>b</emphasis> `, maxSSMLParts) + "</speak>", false},
+		{"chunks over the cap", "<speak>" + strings.Repeat(`w<break time="1ms"/>`, maxSSMLParts*3/4) + strings.Repeat("Sentence. ", 9000) + "</speak>", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseSSML(tt.input)
+			if tt.ok && err != nil {
+				t.Errorf("parseSSML: %v", err)
+			}
+			var ssmlErr *ssmlError
+			if !tt.ok && !errors.As(err, &ssmlErr) {
+				t.Errorf("parseSSML error = %v, want an *ssmlError", err)
+			}
+		})
+	}
+}
+
+func TestParseSSMLEmpty(t *testing.T) {
+	for _, input := range []string{"<speak></speak>", `<speak><break time="1s"/></speak>`, "<speak>  </speak>"} {
+		if _, err := parseSSML(input); err == nil {
+			t.Errorf("parseSSML(%q) accepted a document with no text", input)
+		}
+	}
+}
+
+func TestSayAs(t *testing.T) {
+	tests := []struct {
+		kind, text, want string
+	}{
+		{"characters", "AB C", "A B C"},
+		{"spell-out", "hi", "h i"},
+		{"digits", "1a23", "1 2 3"},
+		{"telephone", "(555) 0100", "5 5 5, 0 1 0 0"},
+		{"ordinal", "1", "1st"},
+		{"ordinal", "2", "2nd"},
+		{"ordinal", "3", "3rd"},
+		{"ordinal", "11", "11th"},
+		{"ordinal", "112", "112th"},
+		{"ordinal", "21", "21st"},
+		{"ordinal", "x", "x"},
+		{"cardinal", "1,234,567", "1234567"},
+		{"expletive", "darn", "beep"},
+		{"date", "2024-01-02", "2024-01-02"},
+	}
+	for _, tt := range tests {
+		got, err := sayAs(tt.kind, tt.text)
+		if err != nil {
+			t.Errorf("sayAs(%q, %q): %v", tt.kind, tt.text, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("sayAs(%q, %q) = %q, want %q", tt.kind, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSSMLPlan(t *testing.T) {
+	doc, err := parseSSML(`<speak><break time="100ms"/>One<break time="500ms"/><prosody rate="fast">two</prosody><break time="200ms"/></speak>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := speechPlan{
+		leadingMs:  100,
+		trailingMs: 200,
+		parts: []speechPart{
+			{text: "One"},
+			{text: "two", style: "quickly", pauseMs: 500},
+		},
+	}
+	if got := doc.plan(defaultChunkPauseMs); !reflect.DeepEqual(got, want) {
+		t.Errorf("plan = %+v, want %+v", got, want)
+	}
+}
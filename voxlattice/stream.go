@@ -0,0 +1,114 @@
+package voxlattice
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// wantsStreaming reports whether the client opted into chunked audio, either
+// with ?stream=true or with a stream=true parameter on the Accept media type.
+func wantsStreaming(r *http.Request) bool {
+	if v := strings.TrimSpace(r.URL.Query().Get("stream")); v != "" {
+		on, err := strconv.ParseBool(v)
+		return err == nil && on
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		_, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if on, err := strconv.ParseBool(params["stream"]); err == nil && on {
+			return true
+		}
+	}
+	return false
+}
+
+// audioStreamWriter encodes PCM chunks as they arrive and flushes each one to
+// the client so playback can start immediately. Headers are deferred until
+// the first chunk so that failures before any audio arrives can still be
+// reported with a proper status code.
+type audioStreamWriter struct {
+	w           http.ResponseWriter
+	flusher     http.Flusher
+	format      audioFormat
+	opts        encodeOptions
+	enc         pcmEncoder
+	wroteHeader bool
+	written     int64
+}
+
+func (s *audioStreamWriter) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	s.written += int64(n)
+	return n, err
+}
+
+func (s *audioStreamWriter) start() error {
+	if s.wroteHeader {
+		return nil
+	}
+	s.wroteHeader = true
+	h := s.w.Header()
+	h.Set("Content-Type", s.format.mediaType(s.opts))
+	h.Set("Cache-Control", "no-store")
+	h.Set("X-Accel-Buffering", "no")
+	s.w.WriteHeader(http.StatusOK)
+	enc, err := s.format.Stream(s, s.opts)
+	if err != nil {
+		return err
+	}
+	s.enc = enc
+	s.flusher.Flush()
+	return nil
+}
+
+func (s *audioStreamWriter) WritePCM(pcm []byte) error {
+	if err := s.start(); err != nil {
+		return err
+	}
+	if err := s.enc.Write(pcm); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+func (s *audioStreamWriter) finish() error {
+	if err := s.start(); err != nil {
+		return err
+	}
+	if err := s.enc.Close(); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// streamTTS runs synthesize and streams its PCM to the client as it arrives.
+// It returns nil only when the whole stream was delivered.
+func streamTTS(w http.ResponseWriter, r *http.Request, flusher http.Flusher, format audioFormat, opts encodeOptions, synthesize func(emit func([]byte) error) error) error {
+	sw := &audioStreamWriter{w: w, flusher: flusher, format: format, opts: opts}
+	err := synthesize(sw.WritePCM)
+	if err != nil {
+		if !sw.wroteHeader {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return err
+		}
+		// Headers are already on the wire; all we can do is stop the stream.
+		// The encoder is still closed so it can release its resources.
+		if sw.enc != nil {
+			_ = sw.enc.Close()
+		}
+		reqLog(r).Warnf("tts stream aborted after %d bytes: %v", sw.written, err)
+		return err
+	}
+	if err := sw.finish(); err != nil {
+		reqLog(r).Warnf("tts stream write failed: %v", err)
+		return err
+	}
+	reqLog(r).Debugf("tts stream finished: %s, %d bytes", format.name, sw.written)
+	return nil
+}
@@ -0,0 +1,150 @@
+package voxlattice
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// synthRequest carries everything a backend needs for one utterance.
+type synthRequest struct {
+	Text   string
+	Voice  string
+	Lang   string
+	Model  string
+	APIKey string
+	Style  string // Delivery instruction from SSML, e.g. "slowly, with emphasis"
+	// onTranscript, when set, receives the text the backend actually spoke,
+	// in order, right after the audio it belongs to has been emitted.
+	// Backends that cannot transcribe leave it uncalled.
+	onTranscript func(text string)
+	// fidelity, when set, accumulates how well the transcripts matched.
+	fidelity *fidelityReport
+	// passThrough sends audio on as it arrives: transcripts are still
+	// checked, but chunks are not held back for retries.
+	passThrough bool
+}
+
+// synthesizer is a speech backend. Implementations stream 24 kHz mono
+// 16-bit PCM to emit as it is produced and stop when emit returns an error.
+type synthesizer interface {
+	synthesize(ctx context.Context, req synthRequest, emit func(pcm []byte) error) error
+	// requiresAPIKey reports whether requests must carry a Gemini API key.
+	requiresAPIKey() bool
+}
+
+// dialogueRequest carries a multi-speaker script for one synthesis call.
+type dialogueRequest struct {
+	Turns  []dialogueTurn
+	Voices map[string]string // Speaker name -> voice
+	Lang   string
+	Model  string
+	APIKey string
+}
+
+// dialogueSynthesizer is implemented by backends that can voice a whole
+// multi-speaker script in one call instead of turn by turn.
+type dialogueSynthesizer interface {
+	supportsDialogue(req dialogueRequest) bool
+	synthesizeDialogue(ctx context.Context, req dialogueRequest, emit func(pcm []byte) error) error
+}
+
+// transcribingSynthesizer is implemented by backends that report what they
+// spoke through synthRequest.onTranscript, for the models that can.
+type transcribingSynthesizer interface {
+	transcribes(model string) bool
+}
+
+// backendTranscribes reports whether the active backend should deliver a
+// transcript for model when one is asked for.
+func backendTranscribes(model string) bool {
+	t, ok := activeBackend.(transcribingSynthesizer)
+	return ok && t.transcribes(model)
+}
+
+// speechSession speaks a series of texts with the settings it was opened
+// with, in order, as one continuous voice. The ctx of speak only carries
+// the trace; the session keeps the lifetime it was opened with.
+type speechSession interface {
+	speak(ctx context.Context, text string, emit func(pcm []byte) error) error
+	Close() error
+}
+
+// sessionSynthesizer is implemented by backends that can keep one upstream
+// session open across utterances.
+type sessionSynthesizer interface {
+	openSession(ctx context.Context, req synthRequest) (speechSession, error)
+}
+
+// openSpeechSession opens a session on the active backend, falling back to
+// one synthesis call per text for backends without sessions.
+func openSpeechSession(ctx context.Context, req synthRequest) (speechSession, error) {
+	if s, ok := activeBackend.(sessionSynthesizer); ok {
+		return s.openSession(ctx, req)
+	}
+	return &oneShotSession{ctx: ctx, backend: activeBackend, req: req}, nil
+}
+
+// oneShotSession adapts a plain synthesizer to speechSession.
+type oneShotSession struct {
+	ctx     context.Context
+	backend synthesizer
+	req     synthRequest
+}
+
+func (s *oneShotSession) speak(ctx context.Context, text string, emit func([]byte) error) error {
+	req := s.req
+	req.Text = text
+	return s.backend.synthesize(detachedContext(s.ctx, ctx), req, emit)
+}
+
+func (s *oneShotSession) Close() error { return nil }
+
+// Available synthesis backends by name
+var synthBackends = map[string]synthesizer{
+	"gemini": geminiSynthesizer{},
+	"fake":   fakeSynthesizer{},
+}
+
+// Active synthesis backend (selected at startup)
+var (
+	activeBackendName             = "gemini"
+	activeBackend     synthesizer = geminiSynthesizer{}
+)
+
+func resolveBackendName(flagVal string) string {
+	if v := strings.TrimSpace(flagVal); v != "" {
+		return strings.ToLower(v)
+	}
+	if v := strings.TrimSpace(os.Getenv("AUDIOMESH_BACKEND")); v != "" {
+		return strings.ToLower(v)
+	}
+	return "gemini"
+}
+
+func selectBackend(name string) error {
+	backend, ok := synthBackends[name]
+	if !ok {
+		names := make([]string, 0, len(synthBackends))
+		for n := range synthBackends {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown backend: %s, available: %v", name, names)
+	}
+	activeBackendName = name
+	activeBackend = backend
+	return nil
+}
+
+// synthError records which step of a synthesis failed so callers can report
+// it without inspecting the underlying error.
+type synthError struct {
+	stage string
+	err   error
+}
+
+func (e *synthError) Error() string { return e.stage + " failed: " + e.err.Error() }
+func (e *synthError) Unwrap() error { return e.err }
@@ -0,0 +1,173 @@
+package voxlattice
+
+import (
+	"context"
+	"math"
+)
+
+const (
+	minSpeed = 0.25
+	maxSpeed = 4.0
+	// WSOLA frame, hop and search tolerance at 24 kHz: 30 ms frames with
+	// 50% overlap, each placed up to 7.5 ms away from its nominal position.
+	tempoFrame     = 720
+	tempoHop       = tempoFrame / 2
+	tempoTolerance = 180
+	// Only every few samples are correlated when searching; the pitch
+	// periods being aligned are much longer than this stride.
+	tempoSearchStride = 4
+)
+
+// tempoStretcher changes the speed of mono 16-bit audio without changing
+// its pitch using WSOLA: frames are taken from the input every speed*hop
+// samples, nudged to line up with the waveform already written, and
+// overlap-added every hop samples. Like resampler it can be fed in chunks.
+type tempoStretcher struct {
+	speed  float64
+	window []float64
+
+	in      []float64 // pending input; in[0] is input sample base
+	base    int64
+	ana     float64 // nominal input position of the next frame
+	prev    int64   // input position of the previous frame, -1 before the first
+	acc     []float64
+	started bool
+}
+
+func newTempoStretcher(speed float64) *tempoStretcher {
+	t := &tempoStretcher{
+		speed:  speed,
+		window: make([]float64, tempoFrame),
+		acc:    make([]float64, tempoFrame),
+		prev:   -1,
+	}
+	// Periodic Hann windows at 50% overlap sum to exactly one.
+	for i := range t.window {
+		t.window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/tempoFrame)
+	}
+	return t
+}
+
+// process consumes in and returns the stretched samples that are complete.
+// With final set it flushes everything that is left.
+func (t *tempoStretcher) process(in []int16, final bool) []int16 {
+	for _, v := range in {
+		t.in = append(t.in, float64(v))
+	}
+	end := t.base + int64(len(t.in))
+
+	var out []int16
+	for {
+		nominal := int64(math.Round(t.ana))
+		if final {
+			if nominal >= end {
+				break
+			}
+		} else if nominal+tempoTolerance+tempoFrame > end || (t.prev >= 0 && t.prev+tempoHop+tempoFrame > end) {
+			break
+		}
+
+		pos := nominal
+		if t.prev >= 0 {
+			pos = t.bestOffset(nominal)
+		}
+		for i := 0; i < tempoFrame; i++ {
+			w := t.window[i]
+			if !t.started && i < tempoHop {
+				w = 1 // nothing precedes the first frame to overlap with
+			}
+			t.acc[i] += t.sample(pos+int64(i)) * w
+		}
+		t.started = true
+		for _, v := range t.acc[:tempoHop] {
+			out = append(out, clampSample(v))
+		}
+		copy(t.acc, t.acc[tempoHop:])
+		clear(t.acc[tempoFrame-tempoHop:])
+
+		t.prev = pos
+		t.ana += float64(tempoHop) * t.speed
+		t.trim()
+	}
+	if final {
+		// The last frame's second half has no successor to overlap with;
+		// it fades out under the window, which ends the audio cleanly.
+		for _, v := range t.acc[:tempoFrame-tempoHop] {
+			out = append(out, clampSample(v))
+		}
+		clear(t.acc)
+	}
+	return out
+}
+
+// bestOffset finds the frame start near nominal whose waveform best
+// continues the previous frame, by normalised cross-correlation against the
+// samples that naturally followed it.
+func (t *tempoStretcher) bestOffset(nominal int64) int64 {
+	ref := t.prev + tempoHop
+	best, bestScore := nominal, math.Inf(-1)
+	lo := max(nominal-tempoTolerance, t.base)
+	for cand := lo; cand <= nominal+tempoTolerance; cand++ {
+		var dot, energy float64
+		for i := int64(0); i < tempoFrame; i += tempoSearchStride {
+			c := t.sample(cand + i)
+			dot += c * t.sample(ref+i)
+			energy += c * c
+		}
+		score := dot
+		if energy > 0 {
+			score = dot / math.Sqrt(energy)
+		}
+		if score > bestScore {
+			best, bestScore = cand, score
+		}
+	}
+	return best
+}
+
+// sample returns input sample idx, or silence outside what is buffered.
+func (t *tempoStretcher) sample(idx int64) float64 {
+	i := idx - t.base
+	if i < 0 || i >= int64(len(t.in)) {
+		return 0
+	}
+	return t.in[i]
+}
+
+// trim drops input no later frame or search can reach.
+func (t *tempoStretcher) trim() {
+	keep := min(t.prev+tempoHop, int64(math.Round(t.ana))-tempoTolerance)
+	if drop := keep - t.base; drop > 0 {
+		drop = min(drop, int64(len(t.in)))
+		t.in = append(t.in[:0], t.in[drop:]...)
+		t.base += drop
+	}
+}
+
+// withSpeed wraps synth so its 24 kHz PCM is played back at speed.
+func withSpeed(speed float64, synth func(ctx context.Context, emit func([]byte) error) error) func(ctx context.Context, emit func([]byte) error) error {
+	if speed == 0 || speed == 1 {
+		return synth
+	}
+	return func(ctx context.Context, emit func([]byte) error) error {
+		ts := newTempoStretcher(speed)
+		var pending []byte
+		err := synth(ctx, func(pcm []byte) error {
+			pending = append(pending, pcm...)
+			n := len(pending) &^ 1
+			out := ts.process(pcmToSamples(pending[:n]), false)
+			pending = append(pending[:0], pending[n:]...)
+			if len(out) == 0 {
+				return nil
+			}
+			return emit(samplesToPCM(out))
+		})
+		if err != nil {
+			return err
+		}
+		if out := ts.process(nil, true); len(out) > 0 {
+			return emit(samplesToPCM(out))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,83 @@
+package voxlattice
+
+import (
+	"context"
+	"math"
+	"slices"
+	"testing"
+)
+
+// zeroCrossingRate is the number of sign changes per second of samples at
+// the service rate, skipping edge samples at each end.
+func zeroCrossingRate(samples []int16, edge int) float64 {
+	samples = samples[edge : len(samples)-edge]
+	n := 0
+	for i := 1; i < len(samples); i++ {
+		if (samples[i-1] < 0) != (samples[i] < 0) {
+			n++
+		}
+	}
+	return float64(n) * sampleRateHz / float64(len(samples))
+}
+
+func TestTempoStretcherLength(t *testing.T) {
+	in := sineSamples(2*sampleRateHz, sampleRateHz, 220, 10000)
+	for _, speed := range []float64{minSpeed, 0.5, 1.5, 2, maxSpeed} {
+		out := newTempoStretcher(speed).process(in, true)
+		want := float64(len(in)) / speed
+		if diff := math.Abs(float64(len(out)) - want); diff > tempoFrame {
+			t.Errorf("speed %v: %d samples, want %.0f ± %d", speed, len(out), want, tempoFrame)
+		}
+		// The pitch stays where it was.
+		if zcr := zeroCrossingRate(out, tempoFrame); math.Abs(zcr-440) > 440*0.05 {
+			t.Errorf("speed %v: %.0f zero crossings per second, want about 440", speed, zcr)
+		}
+	}
+}
+
+func TestTempoStretcherChunked(t *testing.T) {
+	in := sineSamples(sampleRateHz, sampleRateHz, 330, 10000)
+	for _, speed := range []float64{minSpeed, 1.5, maxSpeed} {
+		whole := newTempoStretcher(speed).process(in, true)
+		for _, size := range []int{1, 480, 997} {
+			ts := newTempoStretcher(speed)
+			var chunked []int16
+			for rest := in; len(rest) > 0; {
+				n := min(len(rest), size)
+				chunked = append(chunked, ts.process(rest[:n], false)...)
+				rest = rest[n:]
+			}
+			chunked = append(chunked, ts.process(nil, true)...)
+			if !slices.Equal(whole, chunked) {
+				t.Errorf("speed %v in chunks of %d: output differs from one buffer (%d vs %d samples)", speed, size, len(chunked), len(whole))
+			}
+		}
+	}
+}
+
+func TestWithSpeed(t *testing.T) {
+	pcm := samplesToPCM(sineSamples(sampleRateHz, sampleRateHz, 220, 10000))
+	synth := func(ctx context.Context, emit func([]byte) error) error {
+		// Odd-sized pieces split samples across calls
+		for rest := pcm; len(rest) > 0; {
+			n := min(len(rest), 333)
+			if err := emit(rest[:n]); err != nil {
+				return err
+			}
+			rest = rest[n:]
+		}
+		return nil
+	}
+	var out []byte
+	collect := func(b []byte) error {
+		out = append(out, b...)
+		return nil
+	}
+	if err := withSpeed(2, synth)(context.Background(), collect); err != nil {
+		t.Fatal(err)
+	}
+	want := newTempoStretcher(2).process(pcmToSamples(pcm), true)
+	if !slices.Equal(pcmToSamples(out), want) {
+		t.Errorf("withSpeed gave %d samples, want the %d of one buffer", len(out)/2, len(want))
+	}
+}
@@ -0,0 +1,385 @@
+package voxlattice
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// Voice activity is judged on 10 ms frames; a frame counts as speech
+	// when its RMS is above this share of the loudest frame (or the floor).
+	vadFrameMs    = 10
+	vadRelative   = 0.05
+	vadFloorRMS   = 100
+	maxCueChars   = 42
+	maxCueMs      = 5000
+	cueBreakGapMs = 700
+)
+
+// timingFormat renders aligned words as a timing document.
+type timingFormat struct {
+	name        string
+	contentType string
+	render      func(words []timedWord) []byte
+}
+
+var timingFormats = map[string]timingFormat{
+	"marks": {name: "marks", contentType: "application/x-json-stream", render: renderSpeechMarks},
+	"srt":   {name: "srt", contentType: "application/x-subrip; charset=utf-8", render: renderSRT},
+	"vtt":   {name: "vtt", contentType: "text/vtt; charset=utf-8", render: renderVTT},
+}
+
+// timedWord is one spoken word placed on the output audio, in ms.
+type timedWord struct {
+	value   string
+	startMs int
+	endMs   int
+}
+
+// speechMark is one line of the "marks" output, after Polly's speech marks.
+type speechMark struct {
+	Time     int    `json:"time"`
+	Duration int    `json:"duration"`
+	Type     string `json:"type"`
+	Value    string `json:"value"`
+}
+
+// transcriptMark records that the transcript up to end had been heard once
+// at bytes of PCM were out.
+type transcriptMark struct {
+	end int
+	at  int
+}
+
+// timingRecorder follows a synthesis: it keeps the PCM handed to emit and
+// notes how much of it existed whenever transcript text arrived, which
+// brackets where each piece of text was spoken.
+type timingRecorder struct {
+	pcm        []byte
+	transcript strings.Builder
+	marks      []transcriptMark
+}
+
+func (t *timingRecorder) wrap(emit func([]byte) error) func([]byte) error {
+	return func(pcm []byte) error {
+		t.pcm = append(t.pcm, pcm...)
+		return emit(pcm)
+	}
+}
+
+func (t *timingRecorder) heard(text string) {
+	t.transcript.WriteString(text)
+	t.marks = append(t.marks, transcriptMark{end: t.transcript.Len(), at: len(t.pcm)})
+}
+
+// words aligns the transcript to the recorded audio. Each piece of text is
+// confined to the audio between the mark before it and its own mark, and
+// its words share the speech frames in that span by length. speed scales
+// the result onto audio played back at that speed.
+func (t *timingRecorder) words(speed float64) []timedWord {
+	text := t.transcript.String()
+	tokens := splitWords(text)
+	if len(tokens) == 0 {
+		return nil
+	}
+	bytesPerFrame := sampleRateHz / 1000 * vadFrameMs * channels * bitsPerSample / 8
+	speech := voiceActivity(t.pcm, bytesPerFrame)
+	total := len(speech)
+
+	// Group the words by the mark that completed them; a span without
+	// audio joins the next one, and the last span runs to the end.
+	type span struct {
+		words    []wordToken
+		from, to int // frames
+	}
+	var spans []span
+	var pending []wordToken
+	from, mi := 0, 0
+	for _, tok := range tokens {
+		for mi < len(t.marks)-1 && t.marks[mi].end < tok.end {
+			if to := t.marks[mi].at / bytesPerFrame; to > from {
+				if len(pending) > 0 {
+					spans = append(spans, span{words: pending, from: from, to: to})
+					pending = nil
+				}
+				from = to
+			}
+			mi++
+		}
+		pending = append(pending, tok)
+	}
+	if len(pending) > 0 {
+		if from < total || len(spans) == 0 {
+			spans = append(spans, span{words: pending, from: from, to: total})
+		} else {
+			last := &spans[len(spans)-1]
+			last.words = append(last.words, pending...)
+		}
+	}
+	spans[len(spans)-1].to = max(total, spans[len(spans)-1].to)
+
+	var out []timedWord
+	for _, s := range spans {
+		out = append(out, placeWords(s.words, speech, s.from, s.to)...)
+	}
+	if speed != 0 && speed != 1 {
+		for i := range out {
+			out[i].startMs = int(math.Round(float64(out[i].startMs) / speed))
+			out[i].endMs = int(math.Round(float64(out[i].endMs) / speed))
+		}
+	}
+	return out
+}
+
+// placeWords spreads words over the speech frames of [from, to) in
+// proportion to their length; silent frames are skipped unless the span
+// has no speech at all.
+func placeWords(words []wordToken, speech []bool, from, to int) []timedWord {
+	// The stitcher holds back the last chunkFadeMs of audio until more
+	// follows, so a word can spill a frame or two past its mark. Speech
+	// carried over like that belongs to the previous span.
+	start := from
+	if from > 0 && from < len(speech) && speech[from-1] {
+		carry := from
+		for carry < to && carry < len(speech) && speech[carry] && carry-from <= chunkFadeMs/vadFrameMs {
+			carry++
+		}
+		for f := carry; f < to && f < len(speech); f++ {
+			if speech[f] {
+				start = carry
+				break
+			}
+		}
+	}
+	var frames []int
+	for f := start; f < to && f < len(speech); f++ {
+		if speech[f] {
+			frames = append(frames, f)
+		}
+	}
+	if len(frames) == 0 {
+		for f := from; f < to; f++ {
+			frames = append(frames, f)
+		}
+	}
+	if len(frames) == 0 {
+		frames = []int{from}
+	}
+
+	weight := 0
+	for _, w := range words {
+		weight += w.weight()
+	}
+	out := make([]timedWord, 0, len(words))
+	done := 0
+	for _, w := range words {
+		first := done * len(frames) / weight
+		done += w.weight()
+		last := max(done*len(frames)/weight, first+1) - 1
+		last = min(last, len(frames)-1)
+		out = append(out, timedWord{
+			value:   w.value,
+			startMs: frames[first] * vadFrameMs,
+			endMs:   (frames[last] + 1) * vadFrameMs,
+		})
+	}
+	return out
+}
+
+// voiceActivity returns one flag per frame of pcm telling speech from silence.
+func voiceActivity(pcm []byte, bytesPerFrame int) []bool {
+	n := (len(pcm) + bytesPerFrame - 1) / bytesPerFrame
+	rms := make([]float64, n)
+	peak := 0.0
+	for f := range rms {
+		chunk := pcm[f*bytesPerFrame : min((f+1)*bytesPerFrame, len(pcm))]
+		var sum float64
+		samples := pcmToSamples(chunk)
+		for _, s := range samples {
+			sum += float64(s) * float64(s)
+		}
+		if len(samples) > 0 {
+			rms[f] = math.Sqrt(sum / float64(len(samples)))
+		}
+		peak = max(peak, rms[f])
+	}
+	threshold := max(peak*vadRelative, vadFloorRMS)
+	speech := make([]bool, n)
+	for f, v := range rms {
+		speech[f] = v >= threshold
+	}
+	return speech
+}
+
+type wordToken struct {
+	value string
+	end   int // byte offset just past the word in the transcript
+}
+
+func (w wordToken) weight() int {
+	n := 0
+	for _, r := range w.value {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			n++
+		}
+	}
+	return max(n, 1)
+}
+
+// splitWords splits a transcript on whitespace. Scripts written without
+// spaces are split per character, and punctuation stays with the word it
+// follows.
+func splitWords(text string) []wordToken {
+	var out []wordToken
+	start := -1
+	flush := func(end int) {
+		if start >= 0 {
+			out = append(out, wordToken{value: text[start:end], end: end})
+			start = -1
+		}
+	}
+	for i, r := range text {
+		size := utf8.RuneLen(r)
+		switch {
+		case unicode.IsSpace(r):
+			flush(i)
+		case isCJKRune(r) && !unicode.IsPunct(r):
+			flush(i)
+			start = i
+			flush(i + size)
+		case unicode.IsPunct(r) && start < 0 && len(out) > 0 && out[len(out)-1].end == i:
+			// Trailing punctuation after a CJK character
+			out[len(out)-1].value += string(r)
+			out[len(out)-1].end = i + size
+		default:
+			if start < 0 {
+				start = i
+			}
+		}
+	}
+	flush(len(text))
+	return out
+}
+
+func renderSpeechMarks(words []timedWord) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	for _, w := range words {
+		_ = enc.Encode(speechMark{Time: w.startMs, Duration: w.endMs - w.startMs, Type: "word", Value: w.value})
+	}
+	return buf.Bytes()
+}
+
+// timingCue is a subtitle line made of consecutive words.
+type timingCue struct {
+	text    string
+	startMs int
+	endMs   int
+}
+
+// subtitleCues groups words into cues that end at sentence ends and long
+// pauses and stay within maxCueChars and maxCueMs.
+func subtitleCues(words []timedWord) []timingCue {
+	var cues []timingCue
+	var cur *timingCue
+	for i, w := range words {
+		if cur != nil {
+			text := joinWords(cur.text, w.value)
+			prev := words[i-1].value
+			if utf8.RuneCountInString(text) > maxCueChars || w.endMs-cur.startMs > maxCueMs ||
+				w.startMs-cur.endMs > cueBreakGapMs || endsSentence(prev) {
+				cur = nil
+			} else {
+				cur.text = text
+				cur.endMs = w.endMs
+				continue
+			}
+		}
+		cues = append(cues, timingCue{text: w.value, startMs: w.startMs, endMs: w.endMs})
+		cur = &cues[len(cues)-1]
+	}
+	return cues
+}
+
+func joinWords(a, b string) string {
+	last, _ := utf8.DecodeLastRuneInString(a)
+	first, _ := utf8.DecodeRuneInString(b)
+	if isCJKRune(last) || isCJKRune(first) || isCJKPunct(first) {
+		return a + b
+	}
+	return a + " " + b
+}
+
+func isCJKPunct(r rune) bool {
+	return unicode.IsPunct(r) && r > unicode.MaxLatin1
+}
+
+func endsSentence(word string) bool {
+	word = strings.TrimRight(word, `"'”’)]」』`)
+	r, _ := utf8.DecodeLastRuneInString(word)
+	return strings.ContainsRune(".!?。！？", r)
+}
+
+func renderSRT(words []timedWord) []byte {
+	var b strings.Builder
+	for i, c := range subtitleCues(words) {
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n", i+1, cueTime(c.startMs, ','), cueTime(c.endMs, ','), c.text)
+	}
+	return []byte(b.String())
+}
+
+func renderVTT(words []timedWord) []byte {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n\n")
+	for _, c := range subtitleCues(words) {
+		fmt.Fprintf(&b, "%s --> %s\n%s\n\n", cueTime(c.startMs, '.'), cueTime(c.endMs, '.'), c.text)
+	}
+	return []byte(b.String())
+}
+
+// cueTime formats ms as HH:MM:SS followed by sep and milliseconds.
+func cueTime(ms int, sep byte) string {
+	return fmt.Sprintf("%02d:%02d:%02d%c%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
+
+// multipartTimed packs the audio and its timing document into one
+// multipart/mixed body using boundary.
+func multipartTimed(boundary string, audio []byte, audioType string, timing []byte, tf timingFormat) ([]byte, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return nil, err
+	}
+	parts := []struct {
+		contentType string
+		name        string
+		body        []byte
+	}{
+		{audioType, "audio", audio},
+		{tf.contentType, tf.name, timing},
+	}
+	for _, p := range parts {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Type", p.contentType)
+		h.Set("Content-Disposition", fmt.Sprintf(`inline; name=%q`, p.name))
+		pw, err := mw.CreatePart(h)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := pw.Write(p.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
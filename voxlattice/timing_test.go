@@ -0,0 +1,111 @@
+package voxlattice
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"Hello, world.", []string{"Hello,", "world."}},
+		{" spaced   out\n", []string{"spaced", "out"}},
+		{"你好，世界。", []string{"你", "好，", "世", "界。"}},
+		{"Go语言", []string{"Go", "语", "言"}},
+		{"我用 Go 写", []string{"我", "用", "Go", "写"}},
+		{"「你好」", []string{"「", "你", "好」"}},
+		{"こんにちは!", []string{"こ", "ん", "に", "ち", "は!"}},
+	}
+	for _, tt := range tests {
+		tokens := splitWords(tt.text)
+		var got []string
+		for _, w := range tokens {
+			got = append(got, w.value)
+			if !strings.HasSuffix(tt.text[:w.end], w.value) {
+				t.Errorf("splitWords(%q): %q ends at %d, which does not end with it", tt.text, w.value, w.end)
+			}
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("splitWords(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+// evenWords times each word to take ms, back to back from 0.
+func evenWords(ms int, values ...string) []timedWord {
+	words := make([]timedWord, len(values))
+	for i, v := range values {
+		words[i] = timedWord{value: v, startMs: i * ms, endMs: (i + 1) * ms}
+	}
+	return words
+}
+
+func TestSubtitleCues(t *testing.T) {
+	ten := strings.Repeat("a", 10)
+	tests := []struct {
+		name  string
+		words []timedWord
+		want  []timingCue
+	}{
+		{"empty", nil, nil},
+		{"one cue", evenWords(300, "Hello", "there", "world"), []timingCue{{"Hello there world", 0, 900}}},
+		{"sentence end", evenWords(300, "Hi.", "Bye"), []timingCue{{"Hi.", 0, 300}, {"Bye", 300, 600}}},
+		{"quoted sentence end", evenWords(300, `"Hi!"`, "Bye"), []timingCue{{`"Hi!"`, 0, 300}, {"Bye", 300, 600}}},
+		{"cjk joined", evenWords(200, "你", "好，", "世", "界。", "再"), []timingCue{{"你好，世界。", 0, 800}, {"再", 800, 1000}}},
+		{"latin then cjk", evenWords(200, "用", "Go", "写"), []timingCue{{"用Go写", 0, 600}}},
+		{"pause", []timedWord{{"one", 0, 300}, {"two", 1100, 1400}, {"three", 1500, 1800}}, []timingCue{{"one", 0, 300}, {"two three", 1100, 1800}}},
+		{"short pause", []timedWord{{"one", 0, 300}, {"two", 1000, 1300}}, []timingCue{{"one two", 0, 1300}}},
+		{"max chars", evenWords(100, ten, ten, ten, ten, ten), []timingCue{{ten + " " + ten + " " + ten, 0, 300}, {ten + " " + ten, 300, 500}}},
+		{"max duration", evenWords(1000, "a", "b", "c", "d", "e", "f"), []timingCue{{"a b c d e", 0, 5000}, {"f", 5000, 6000}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := subtitleCues(tt.words); !slices.Equal(got, tt.want) {
+				t.Errorf("subtitleCues = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCueTime(t *testing.T) {
+	tests := []struct {
+		ms   int
+		sep  byte
+		want string
+	}{
+		{0, ',', "00:00:00,000"},
+		{999, ',', "00:00:00,999"},
+		{1000, '.', "00:00:01.000"},
+		{61001, ',', "00:01:01,001"},
+		{3723004, '.', "01:02:03.004"},
+		{36000000, ',', "10:00:00,000"},
+	}
+	for _, tt := range tests {
+		if got := cueTime(tt.ms, tt.sep); got != tt.want {
+			t.Errorf("cueTime(%d, %q) = %s, want %s", tt.ms, tt.sep, got, tt.want)
+		}
+	}
+}
+
+func TestRenderTiming(t *testing.T) {
+	words := []timedWord{{"Hello.", 0, 450}, {"世", 1500, 1700}, {"界", 1700, 1900}}
+	srt := "1\n00:00:00,000 --> 00:00:00,450\nHello.\n\n2\n00:00:01,500 --> 00:00:01,900\n世界\n\n"
+	if got := string(renderSRT(words)); got != srt {
+		t.Errorf("renderSRT = %q, want %q", got, srt)
+	}
+	vtt := "WEBVTT\n\n00:00:00.000 --> 00:00:00.450\nHello.\n\n00:00:01.500 --> 00:00:01.900\n世界\n\n"
+	if got := string(renderVTT(words)); got != vtt {
+		t.Errorf("renderVTT = %q, want %q", got, vtt)
+	}
+	marks := `{"time":0,"duration":450,"type":"word","value":"Hello."}` + "\n" +
+		`{"time":1500,"duration":200,"type":"word","value":"世"}` + "\n" +
+		`{"time":1700,"duration":200,"type":"word","value":"界"}` + "\n"
+	if got := string(renderSpeechMarks(words)); got != marks {
+		t.Errorf("renderSpeechMarks = %q, want %q", got, marks)
+	}
+}
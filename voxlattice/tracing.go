@@ -0,0 +1,97 @@
+package voxlattice
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer records the spans of every request. Until initTracing installs an
+// exporter the global provider is a no-op and spans cost next to nothing.
+var tracer = otel.Tracer("voxlattice")
+
+// initTracing exports spans over OTLP/HTTP to endpoint, or to the endpoint
+// in the standard OTEL_EXPORTER_OTLP_* variables when it is empty. It
+// reports false when neither is set and tracing stays off. Inbound W3C
+// traceparent headers are honored either way.
+func initTracing(endpoint, serviceName string) (bool, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var opts []otlptracehttp.Option
+	if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+		opts = append(opts, otlptracehttp.WithEndpointURL(otlpTracesURL(endpoint)))
+	} else if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return false, nil
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return false, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", serviceName),
+	))
+	if err != nil {
+		return false, err
+	}
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	))
+	return true, nil
+}
+
+// otlpTracesURL turns a collector address such as localhost:4318 into the
+// URL its traces are posted to.
+func otlpTracesURL(endpoint string) string {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return endpoint
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	return u.String()
+}
+
+// startSpan starts a span for one phase of handling a request.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends span, marking it failed when err is set.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// speechAttrs describes an utterance on a span.
+func speechAttrs(req synthRequest) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("tts.voice", req.Voice),
+		attribute.String("tts.lang", req.Lang),
+		attribute.String("tts.model", strings.TrimPrefix(req.Model, "models/")),
+		attribute.Int("tts.text_length", len([]rune(req.Text))),
+	}
+}
+
+// detachedContext keeps the lifetime of base but puts new spans under the
+// span of parent, for long-lived sessions used on behalf of many requests.
+func detachedContext(base, parent context.Context) context.Context {
+	return trace.ContextWithSpan(base, trace.SpanFromContext(parent))
+}
@@ -3,7 +3,8 @@ package voxlattice
 import (
 	"bytes"
 	"context"
-	"encoding/binary"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,69 +14,9 @@ import (
 	"strings"
 	"time"
 
-	"google.golang.org/genai"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-func pcmToWav(pcm []byte) ([]byte, error) {
-	byteRate := sampleRateHz * channels * bitsPerSample / 8
-	blockAlign := channels * bitsPerSample / 8
-	dataLen := uint32(len(pcm))
-	riffLen := 36 + dataLen
-
-	buf := &bytes.Buffer{}
-	buf.WriteString("RIFF")
-	if err := binary.Write(buf, binary.LittleEndian, uint32(riffLen)); err != nil {
-		return nil, err
-	}
-	buf.WriteString("WAVE")
-	buf.WriteString("fmt ")
-	if err := binary.Write(buf, binary.LittleEndian, uint32(16)); err != nil {
-		return nil, err
-	}
-	if err := binary.Write(buf, binary.LittleEndian, uint16(1)); err != nil {
-		return nil, err
-	}
-	if err := binary.Write(buf, binary.LittleEndian, uint16(channels)); err != nil {
-		return nil, err
-	}
-	if err := binary.Write(buf, binary.LittleEndian, uint32(sampleRateHz)); err != nil {
-		return nil, err
-	}
-	if err := binary.Write(buf, binary.LittleEndian, uint32(byteRate)); err != nil {
-		return nil, err
-	}
-	if err := binary.Write(buf, binary.LittleEndian, uint16(blockAlign)); err != nil {
-		return nil, err
-	}
-	if err := binary.Write(buf, binary.LittleEndian, uint16(bitsPerSample)); err != nil {
-		return nil, err
-	}
-	buf.WriteString("data")
-	if err := binary.Write(buf, binary.LittleEndian, dataLen); err != nil {
-		return nil, err
-	}
-	buf.Write(pcm)
-
-	return buf.Bytes(), nil
-}
-
-func getRequestAPIKey(r *http.Request) string {
-	if r == nil {
-		return ""
-	}
-	if v := strings.TrimSpace(r.Header.Get("X-Gemini-Api-Key")); v != "" {
-		return v
-	}
-	if v := strings.TrimSpace(r.Header.Get("X-API-Key")); v != "" {
-		return v
-	}
-	auth := strings.TrimSpace(r.Header.Get("Authorization"))
-	if len(auth) >= 7 && strings.EqualFold(auth[:7], "Bearer ") {
-		return strings.TrimSpace(auth[7:])
-	}
-	return ""
-}
-
 func getListenAddr() (string, error) {
 	port := strings.TrimSpace(os.Getenv("AUDIOMESH_PORT"))
 	if port == "" {
@@ -93,11 +34,17 @@ func getListenAddr() (string, error) {
 }
 
 func parseTTSRequest(r *http.Request) (ttsReq, error) {
-	var out ttsReq
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		return out, err
+		return ttsReq{}, err
 	}
+	return parseTTSBody(body)
+}
+
+// parseTTSBody parses one /tts request object; text may also be an array
+// of strings, joined by newlines.
+func parseTTSBody(body []byte) (ttsReq, error) {
+	var out ttsReq
 	var raw map[string]json.RawMessage
 	if err := json.Unmarshal(body, &raw); err != nil {
 		return out, err
@@ -109,9 +56,40 @@ func parseTTSRequest(r *http.Request) (ttsReq, error) {
 	if v, ok := raw["lang"]; ok && len(v) > 0 {
 		_ = json.Unmarshal(v, &out.Lang)
 	}
+	if v, ok := raw["model"]; ok && len(v) > 0 {
+		_ = json.Unmarshal(v, &out.Model)
+	}
+	if v, ok := raw["format"]; ok && len(v) > 0 {
+		_ = json.Unmarshal(v, &out.Format)
+	}
+	if v, ok := raw["bitrate"]; ok && len(v) > 0 {
+		_ = json.Unmarshal(v, &out.Bitrate)
+	}
+	if v, ok := raw["sample_rate"]; ok && len(v) > 0 {
+		_ = json.Unmarshal(v, &out.SampleRate)
+	}
+	if v, ok := raw["speed"]; ok && len(v) > 0 {
+		_ = json.Unmarshal(v, &out.Speed)
+	}
+	if v, ok := raw["chunk_pause_ms"]; ok && len(v) > 0 {
+		_ = json.Unmarshal(v, &out.ChunkPauseMs)
+	}
+	if v, ok := raw["timing_format"]; ok && len(v) > 0 {
+		_ = json.Unmarshal(v, &out.TimingFormat)
+	}
+	if v, ok := raw["callback_url"]; ok && len(v) > 0 {
+		_ = json.Unmarshal(v, &out.CallbackURL)
+	}
+
+	if v, ok := raw["ssml"]; ok && len(v) > 0 {
+		_ = json.Unmarshal(v, &out.SSML)
+	}
 
 	textRaw, ok := raw["text"]
 	if !ok || len(textRaw) == 0 {
+		if out.SSML != "" {
+			return out, nil
+		}
 		return out, errors.New("text is required")
 	}
 
@@ -199,7 +177,7 @@ func ttsHandler(w http.ResponseWriter, r *http.Request) {
 	// Allow CORS for web access
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, X-Gemini-Api-Key")
 
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusNoContent)
@@ -210,130 +188,473 @@ func ttsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	_, parseSpan := startSpan(r.Context(), "tts.parse")
 	req, err := parseTTSRequest(r)
 	if err != nil {
+		endSpan(parseSpan, err)
 		http.Error(w, "invalid json", http.StatusBadRequest)
 		return
 	}
 
-	normalized, err := normalizeText(req.Text)
+	// With a callback the request runs as a one-item job instead
+	if req.CallbackURL != "" {
+		callbackURL := req.CallbackURL
+		req.CallbackURL = ""
+		item, err := prepareJobItem(req, r.Header.Get("Accept"))
+		endSpan(parseSpan, err)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		submitJob(w, r, []ttsReq{item}, callbackURL)
+		return
+	}
+
+	p, err := prepareTTS(req, r.Header.Get("Accept"))
+	endSpan(parseSpan, err)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	req.Text = normalized
 
-	// Validate voice if provided
-	if req.Voice != "" {
-		// Normalize voice name to lowercase
-		req.Voice = strings.ToLower(req.Voice)
-		if _, exists := supportedVoices[req.Voice]; !exists {
-			http.Error(w, fmt.Sprintf("unsupported voice: %s, supported voices: %v", req.Voice, getSupportedVoiceNames()), http.StatusBadRequest)
+	auth, err := resolveAPIKey(r)
+	if err == nil {
+		err = auth.checkAccess(p.model, p.req.Voice)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), authErrorStatus(err))
+		return
+	}
+	adm, err := admitRequest(r, auth, p.text())
+	if err != nil {
+		setRetryAfter(w, err)
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	defer adm.done()
+	noteSpeech(r, p.req.Voice, p.text())
+
+	sreq := p.synthRequest(auth.apiKey)
+	// Only filled in when this request runs the synthesis itself
+	sreq.fidelity = &fidelityReport{}
+	contentType := p.contentType()
+	if audioCache != nil {
+		if body, ok := audioCache.Get(p.key); ok {
+			w.Header().Set("X-Cache", "HIT")
+			writeBody(w, contentType, body)
 			return
 		}
+		w.Header().Set("X-Cache", "MISS")
+	}
+	if err := adm.charge(r); err != nil {
+		setRetryAfter(w, err)
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
 	}
 
-	apiKey := getRequestAPIKey(r)
-	if apiKey == "" {
-		apiKey = strings.TrimSpace(os.Getenv("GEMINI_API_KEY"))
-		if apiKey == "" || apiKey == "your_api_key_here" {
-			http.Error(w, "missing api key", http.StatusUnauthorized)
+	// Long texts are synthesized chunk by chunk; give each chunk the time a
+	// single request used to get and extend the write deadline to match.
+	plan := p.plan()
+	timeout := planTimeout(plan)
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout + 5*time.Second))
+	if len(plan.parts) > 1 {
+		reqLog(r).Debugf("tts text split into %d chunks", len(plan.parts))
+	}
+
+	// Timings need the whole transcript, so those are never streamed
+	if p.timing.name == "" && wantsStreaming(r) {
+		if flusher, ok := w.(http.Flusher); ok {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			// Holding chunks back for the transcript check would turn the
+			// stream into chunk-at-a-time delivery, so only on request
+			sreq.passThrough = !p.req.VerifyTranscript
+			synth := p.synth(sreq, plan)
+			// Keep the PCM so the finished stream can still be cached.
+			var captured bytes.Buffer
+			err := streamTTS(w, r, flusher, p.format, p.opts, func(emit func([]byte) error) error {
+				return synth(ctx, func(chunk []byte) error {
+					if audioCache != nil {
+						captured.Write(chunk)
+					}
+					return emit(chunk)
+				})
+			})
+			if err != nil {
+				adm.refund()
+				if r.Context().Err() == nil {
+					countRequestError(r, err)
+				}
+			}
+			if err == nil && audioCache != nil {
+				if audio, err := p.format.Encode(captured.Bytes(), p.opts); err == nil {
+					storeCachedAudio(p.key, audio)
+				}
+			}
+			// Headers are long gone; the score only goes to the log
+			sreq.fidelity.log(reqLog(r))
 			return
 		}
+		reqLog(r).Warnf("streaming requested but response writer cannot flush, falling back to buffered output")
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
-	defer cancel()
-
-	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey:  apiKey,
-		Backend: genai.BackendGeminiAPI,
-	})
+	body, shared, err := p.render(r.Context(), sreq, plan, timeout)
 	if err != nil {
-		http.Error(w, "client init failed: "+err.Error(), http.StatusBadGateway)
+		adm.refund()
+		writeSynthError(w, r, err)
 		return
 	}
-	// Note: genai client typically manages connections itself, no explicit close needed
+	if shared {
+		reqLog(r).Debugf("tts served from shared in-flight synthesis")
+	}
+	reportFidelity(w, r, sreq.fidelity)
+	writeBody(w, contentType, body)
+}
 
-	modelName := getModelName()
+// preparedTTS is a validated /tts request with its output resolved, ready
+// to be synthesized now or later.
+type preparedTTS struct {
+	req        ttsReq
+	doc        ssmlDoc
+	model      string
+	format     audioFormat
+	opts       encodeOptions
+	timing     timingFormat
+	timingOnly bool // timing holds the whole response, there is no audio
+	pauseMs    int
+	key        string
+}
 
-	systemInstruction := &genai.Content{
-		Parts: []*genai.Part{
-			{Text: "You are a TTS engine. Repeat the user's text verbatim. Do not add, remove, translate, or rephrase. Output audio only."},
-		},
+// prepareTTS normalizes and validates req. accept is the Accept header that
+// picks the format when req names none. Every error is the caller's fault.
+func prepareTTS(req ttsReq, accept string) (*preparedTTS, error) {
+	p := &preparedTTS{}
+	var err error
+	if req.SSML != "" {
+		if req.Text != "" {
+			return nil, errors.New("text and ssml are mutually exclusive")
+		}
+		if p.doc, err = parseValidSSML(req.SSML); err != nil {
+			return nil, err
+		}
+	} else {
+		if req.Text, err = normalizeText(req.Text); err != nil {
+			return nil, err
+		}
+	}
+
+	// Validate voice if provided
+	if req.Voice != "" {
+		// Normalize voice name to lowercase
+		req.Voice = strings.ToLower(req.Voice)
+		if _, exists := currentVoices()[req.Voice]; !exists {
+			return nil, fmt.Errorf("unsupported voice: %s, supported voices: %v", req.Voice, getSupportedVoiceNames())
+		}
 	}
 
-	cfg := &genai.LiveConnectConfig{
-		ResponseModalities: []genai.Modality{genai.ModalityAudio},
-		Temperature:        genai.Ptr[float32](0),
-		SystemInstruction:  systemInstruction,
+	if p.model, err = resolveModel(req.Model); err != nil {
+		return nil, err
 	}
 
-	// Configure voice if specified
-	if req.Voice != "" {
-		cfg.SpeechConfig = &genai.SpeechConfig{
-			VoiceConfig: &genai.VoiceConfig{
-				PrebuiltVoiceConfig: &genai.PrebuiltVoiceConfig{
-					VoiceName: req.Voice,
-				},
-			},
+	// A timing format in place of an audio format asks for the timings alone
+	if tf, ok := timingFormats[strings.ToLower(strings.TrimSpace(req.Format))]; ok {
+		if req.TimingFormat != "" && !strings.EqualFold(req.TimingFormat, tf.name) {
+			return nil, errors.New("format and timing_format disagree")
+		}
+		p.timing, p.timingOnly = tf, true
+		req.Format, req.TimingFormat = "", tf.name
+	} else if req.TimingFormat != "" {
+		if p.timing, ok = timingFormats[strings.ToLower(strings.TrimSpace(req.TimingFormat))]; !ok {
+			return nil, fmt.Errorf("unsupported timing_format: %s, supported: marks, srt, vtt", req.TimingFormat)
+		}
+		req.TimingFormat = p.timing.name
+	}
+	if !p.timingOnly {
+		if p.format, p.opts, err = resolveFormat(accept, req); err != nil {
+			return nil, err
 		}
 	}
 
-	// If language is specified, we can potentially add language-specific instructions
-	if req.Lang != "" {
-		// Add language-specific instruction to the system instruction
-		langInstruction := fmt.Sprintf("Respond in %s language with appropriate pronunciation.", req.Lang)
-		systemInstruction.Parts = append(systemInstruction.Parts, &genai.Part{Text: langInstruction})
+	p.pauseMs = defaultChunkPauseMs
+	if req.ChunkPauseMs != nil {
+		p.pauseMs = *req.ChunkPauseMs
+	}
+	if p.pauseMs < 0 || p.pauseMs > maxChunkPauseMs {
+		return nil, fmt.Errorf("chunk_pause_ms must be between 0 and %d", maxChunkPauseMs)
 	}
 
-	session, err := client.Live.Connect(ctx, modelName, cfg)
-	if err != nil {
-		http.Error(w, "live connect failed: "+err.Error(), http.StatusBadGateway)
-		return
+	if req.Speed == 0 {
+		req.Speed = 1
+	}
+	if req.Speed < minSpeed || req.Speed > maxSpeed {
+		return nil, fmt.Errorf("speed must be between %g and %g", minSpeed, maxSpeed)
+	}
+
+	p.req = req
+	p.key = synthesisKey(req, p.model, p.format, p.opts, p.pauseMs)
+	return p, nil
+}
+
+func (p *preparedTTS) synthRequest(apiKey string) synthRequest {
+	return synthRequest{
+		Text:   p.req.Text,
+		Voice:  p.req.Voice,
+		Lang:   p.req.Lang,
+		Model:  p.model,
+		APIKey: apiKey,
+	}
+}
+
+// text returns what will be spoken, SSML markup left out.
+func (p *preparedTTS) text() string {
+	if p.req.SSML != "" {
+		return p.doc.text()
+	}
+	return p.req.Text
+}
+
+func (p *preparedTTS) contentType() string {
+	switch {
+	case p.timingOnly:
+		return p.timing.contentType
+	case p.timing.name != "":
+		return "multipart/mixed; boundary=" + timedBoundary(p.key)
+	}
+	return p.format.mediaType(p.opts)
+}
+
+func (p *preparedTTS) plan() speechPlan {
+	return requestPlan(p.req, p.doc, p.pauseMs)
+}
+
+// synth returns the PCM producer for the request at its speed.
+func (p *preparedTTS) synth(sreq synthRequest, plan speechPlan) func(ctx context.Context, emit func([]byte) error) error {
+	return withSpeed(p.req.Speed, func(ctx context.Context, emit func([]byte) error) error {
+		return synthesizePlan(ctx, sreq, plan, emit)
+	})
+}
+
+// render synthesizes the complete response body and caches it.
+func (p *preparedTTS) render(ctx context.Context, sreq synthRequest, plan speechPlan, timeout time.Duration) ([]byte, bool, error) {
+	if p.timing.name != "" {
+		return synthesizeTimed(ctx, p.key, sreq.APIKey, timeout, sreq, plan, p.req.Speed, p.format, p.opts, p.timing, p.timingOnly)
 	}
-	defer session.Close()
+	return synthesizeBuffered(ctx, p.key, sreq.APIKey, timeout, p.format, p.opts, p.synth(sreq, plan))
+}
 
-	turn := genai.NewContentFromText(req.Text, genai.RoleUser)
-	err = session.SendClientContent(genai.LiveClientContentInput{
-		Turns:        []*genai.Content{turn},
-		TurnComplete: genai.Ptr(true),
+// planTimeout gives each chunk of a plan the time a single request gets.
+func planTimeout(plan speechPlan) time.Duration {
+	return chunksTimeout(len(plan.parts))
+}
+
+// chunksTimeout allows chunkTimeout per chunk, up to maxRequestTimeout so
+// no request can hold a connection and an upstream session indefinitely.
+func chunksTimeout(chunks int) time.Duration {
+	return min(time.Duration(chunks)*chunkTimeout, maxRequestTimeout)
+}
+
+// renderSpeech synthesizes an already validated request into encoded audio
+// for endpoints that answer with a complete file, serving it from the cache
+// when possible. It sets X-Cache and extends the write deadline to cover
+// every chunk of the text. adm is only charged on a cache miss; a refusal
+// comes back as a *limitError.
+func renderSpeech(w http.ResponseWriter, r *http.Request, adm *admission, req ttsReq, doc ssmlDoc, sreq synthRequest, format audioFormat, opts encodeOptions, pauseMs int) ([]byte, error) {
+	key := synthesisKey(req, sreq.Model, format, opts, pauseMs)
+	if audioCache != nil {
+		if audio, ok := audioCache.Get(key); ok {
+			w.Header().Set("X-Cache", "HIT")
+			return audio, nil
+		}
+		w.Header().Set("X-Cache", "MISS")
+	}
+	if err := adm.charge(r); err != nil {
+		return nil, err
+	}
+
+	plan := requestPlan(req, doc, pauseMs)
+	timeout := planTimeout(plan)
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout + 5*time.Second))
+	sreq.fidelity = &fidelityReport{}
+	synth := withSpeed(req.Speed, func(ctx context.Context, emit func([]byte) error) error {
+		return synthesizePlan(ctx, sreq, plan, emit)
 	})
+	audio, _, err := synthesizeBuffered(r.Context(), key, sreq.APIKey, timeout, format, opts, synth)
+	if err == nil {
+		reportFidelity(w, r, sreq.fidelity)
+	}
+	return audio, err
+}
+
+// requestPlan returns the synthesis plan for req: the parsed SSML document
+// when the request carried one, its plain text otherwise.
+func requestPlan(req ttsReq, doc ssmlDoc, pauseMs int) speechPlan {
+	if req.SSML != "" {
+		return doc.plan(pauseMs)
+	}
+	return textPlan(req.Text, pauseMs)
+}
+
+// parseValidSSML parses an SSML request and applies the text length limit
+// to what will actually be spoken.
+func parseValidSSML(ssml string) (ssmlDoc, error) {
+	doc, err := parseSSML(ssml)
 	if err != nil {
-		http.Error(w, "clientContent send failed: "+err.Error(), http.StatusBadGateway)
-		return
+		return doc, err
 	}
+	if n := len(doc.text()); n > maxTextLen {
+		return doc, fmt.Errorf("text too long: %d > %d", n, maxTextLen)
+	}
+	return doc, nil
+}
+
+// resolveModel returns the model a request asked for, or GEMINI_MODEL when
+// it did not name one.
+func resolveModel(name string) (string, error) {
+	if name == "" {
+		return getModelName(), nil
+	}
+	model := canonicalModelName(name)
+	if _, ok := lookupModel(model); !ok {
+		return "", fmt.Errorf("unsupported model: %s, supported models: %v", name, getSupportedModelNames())
+	}
+	return model, nil
+}
 
-	var pcm bytes.Buffer
-	for {
-		msg, err := session.Receive()
+// synthesizeBuffered runs synth to completion, encodes the PCM and stores the
+// audio in the cache. Identical requests in flight at the same time share
+// one upstream call; the API key is part of the flight key so callers never
+// ride on credentials other than their own.
+func synthesizeBuffered(ctx context.Context, key, apiKey string, timeout time.Duration, format audioFormat, opts encodeOptions, synth func(ctx context.Context, emit func([]byte) error) error) ([]byte, bool, error) {
+	return synthesizeShared(ctx, key, apiKey, timeout, func(ctx context.Context) ([]byte, error) {
+		var pcm bytes.Buffer
+		err := synth(ctx, func(chunk []byte) error {
+			pcm.Write(chunk)
+			return nil
+		})
 		if err != nil {
-			http.Error(w, "read failed: "+err.Error(), http.StatusBadGateway)
-			return
+			return nil, err
 		}
+		return encodeAudio(ctx, format, pcm.Bytes(), opts)
+	})
+}
 
-		if msg.ServerContent != nil && msg.ServerContent.ModelTurn != nil {
-			for _, p := range msg.ServerContent.ModelTurn.Parts {
-				if p.InlineData != nil && len(p.InlineData.Data) > 0 {
-					pcm.Write(p.InlineData.Data)
-				}
-			}
+// synthesizeShared runs render under timeout, sharing it between identical
+// requests in flight, and caches the body it returns.
+func synthesizeShared(ctx context.Context, key, apiKey string, timeout time.Duration, render func(ctx context.Context) ([]byte, error)) ([]byte, bool, error) {
+	keySum := sha256.Sum256([]byte(apiKey))
+	flightKey := key + ":" + hex.EncodeToString(keySum[:8])
+	return inflight.Do(ctx, flightKey, func(ctx context.Context) ([]byte, error) {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		body, err := render(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if audioCache != nil {
+			storeCachedAudio(key, body)
 		}
+		return body, nil
+	})
+}
 
-		if msg.ServerContent != nil && (msg.ServerContent.TurnComplete || msg.ServerContent.GenerationComplete) {
-			break
+// synthesizeTimed synthesizes plan while recording what was said, and
+// returns the word timings in timing, either alone or packed with the
+// encoded audio as multipart/mixed.
+func synthesizeTimed(ctx context.Context, key, apiKey string, timeout time.Duration, sreq synthRequest, plan speechPlan, speed float64, format audioFormat, opts encodeOptions, timing timingFormat, timingOnly bool) ([]byte, bool, error) {
+	return synthesizeShared(ctx, key, apiKey, timeout, func(ctx context.Context) ([]byte, error) {
+		rec := &timingRecorder{}
+		sreq.onTranscript = rec.heard
+		synth := withSpeed(speed, func(ctx context.Context, emit func([]byte) error) error {
+			return synthesizePlan(ctx, sreq, plan, rec.wrap(emit))
+		})
+		var pcm bytes.Buffer
+		err := synth(ctx, func(chunk []byte) error {
+			pcm.Write(chunk)
+			return nil
+		})
+		if err != nil {
+			return nil, err
 		}
-	}
+		marks := timing.render(rec.words(speed))
+		if timingOnly {
+			return marks, nil
+		}
+		audio, err := encodeAudio(ctx, format, pcm.Bytes(), opts)
+		if err != nil {
+			return nil, err
+		}
+		return multipartTimed(timedBoundary(key), audio, format.mediaType(opts), marks, timing)
+	})
+}
 
-	// Convert to WAV format
-	wav, err := pcmToWav(pcm.Bytes())
-	if err != nil {
-		http.Error(w, "wav encode failed: "+err.Error(), http.StatusInternalServerError)
+// timedBoundary derives the multipart boundary from the cache key so a
+// cached body can be served with the same Content-Type.
+func timedBoundary(key string) string {
+	return "voxlattice-" + key[:32]
+}
+
+// writeSynthError reports a failed synthesis. Nothing is written if the
+// client left.
+func writeSynthError(w http.ResponseWriter, r *http.Request, err error) {
+	if r.Context().Err() != nil {
+		reqLog(r).Debugf("tts client went away: %v", err)
 		return
 	}
+	countRequestError(r, err)
+	var fidErr *fidelityError
+	if errors.As(err, &fidErr) {
+		w.Header().Set("X-Error-Code", fidErr.code())
+	}
+	http.Error(w, err.Error(), synthErrorStatus(err))
+}
+
+// synthErrorStatus maps a synthesis error to an HTTP status: encoder
+// failures are ours, anything else came from upstream.
+func synthErrorStatus(err error) int {
+	var encErr *encodeError
+	if errors.As(err, &encErr) {
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadGateway
+}
+
+func writeAudio(w http.ResponseWriter, format audioFormat, opts encodeOptions, audio []byte) {
+	writeBody(w, format.mediaType(opts), audio)
+}
 
-	w.Header().Set("Content-Type", "audio/wav")
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(wav)))
+func writeBody(w http.ResponseWriter, contentType string, body []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(body)))
 	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(wav)
+	_, _ = w.Write(body)
 }
+
+func storeCachedAudio(key string, audio []byte) {
+	if err := audioCache.Put(key, audio); err != nil {
+		appLog.Warnf("cache write failed: %v", err)
+	}
+}
+
+// encodeAudio encodes synthesized PCM, wrapping failures in encodeError.
+func encodeAudio(ctx context.Context, format audioFormat, pcm []byte, opts encodeOptions) ([]byte, error) {
+	_, span := startSpan(ctx, "tts.encode",
+		attribute.String("tts.format", format.name),
+		attribute.Int("tts.pcm_bytes", len(pcm)),
+	)
+	audio, err := format.Encode(pcm, opts)
+	if err != nil {
+		err = &encodeError{format: format.name, err: err}
+	}
+	span.SetAttributes(attribute.Int("tts.audio_bytes", len(audio)))
+	endSpan(span, err)
+	return audio, err
+}
+
+// encodeError marks a failure to encode audio that was synthesized fine.
+type encodeError struct {
+	format string
+	err    error
+}
+
+func (e *encodeError) Error() string { return e.format + " encode failed: " + e.err.Error() }
+func (e *encodeError) Unwrap() error { return e.err }
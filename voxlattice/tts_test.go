@@ -0,0 +1,144 @@
+package voxlattice
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// useFakeBackend switches to the offline backend for the rest of the test.
+func useFakeBackend(t *testing.T) {
+	t.Helper()
+	name, backend := activeBackendName, activeBackend
+	t.Cleanup(func() { activeBackendName, activeBackend = name, backend })
+	if err := selectBackend("fake"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// useVoices replaces the supported voices for the rest of the test.
+func useVoices(t *testing.T, names ...string) {
+	t.Helper()
+	saved := supportedVoices.Load()
+	t.Cleanup(func() { supportedVoices.Store(saved) })
+	voices := map[string]string{}
+	for _, name := range names {
+		voices[name] = name + " - Test voice"
+	}
+	supportedVoices.Store(&voices)
+}
+
+// serve runs handler on one request and returns the recorded response.
+func serve(handler http.HandlerFunc, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// fakePCMBytes is the length of the fake backend's audio for text that
+// has no spaces and fits in one chunk.
+func fakePCMBytes(text string) int {
+	return len([]rune(text)) * sampleRateHz * fakeRuneMs / 1000 * bitsPerSample / 8
+}
+
+func TestTTSHandler(t *testing.T) {
+	useFakeBackend(t)
+	useVoices(t, "kore", "charon")
+
+	tests := []struct {
+		name        string
+		target      string
+		body        string
+		accept      string
+		contentType string
+		size        int // exact body length when not 0
+		magic       string
+	}{
+		{"wav", "/tts", `{"text":"hello","voice":"kore","format":"wav"}`, "", "audio/wav", 44 + fakePCMBytes("hello"), "RIFF"},
+		{"default voice", "/tts", `{"text":"hello","format":"wav"}`, "", "audio/wav", 44 + fakePCMBytes("hello"), "RIFF"},
+		{"pcm", "/tts", `{"text":"hello","format":"pcm_s16le"}`, "", "audio/pcm; rate=24000", fakePCMBytes("hello"), ""},
+		{"pcm 8k", "/tts", `{"text":"hello","format":"pcm_s16le","sample_rate":8000}`, "", "audio/pcm; rate=8000", fakePCMBytes("hello") / 3, ""},
+		{"mulaw", "/tts", `{"text":"hello","format":"mulaw","sample_rate":8000}`, "", "audio/PCMU; rate=8000", fakePCMBytes("hello") / 6, ""},
+		{"mp3", "/tts", `{"text":"hello","format":"mp3"}`, "", "audio/mpeg", 0, ""},
+		{"accept", "/tts", `{"text":"hello"}`, "audio/mpeg", "audio/mpeg", 0, ""},
+		{"text array", "/tts", `{"text":["hel","lo"],"format":"wav"}`, "", "audio/wav", 0, "RIFF"},
+		{"streamed", "/tts?stream=true", `{"text":"hello","format":"wav"}`, "", "audio/wav", 0, "RIFF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(ttsHandler, http.MethodPost, tt.target, tt.body, "Accept", tt.accept)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, tt.contentType) {
+				t.Errorf("Content-Type %q, want %q", ct, tt.contentType)
+			}
+			if tt.size != 0 && w.Body.Len() != tt.size {
+				t.Errorf("body is %d bytes, want %d", w.Body.Len(), tt.size)
+			}
+			if w.Body.Len() == 0 || !bytes.HasPrefix(w.Body.Bytes(), []byte(tt.magic)) {
+				t.Errorf("body does not start with %q", tt.magic)
+			}
+		})
+	}
+}
+
+func TestTTSHandlerAudible(t *testing.T) {
+	useFakeBackend(t)
+	w := serve(ttsHandler, http.MethodPost, "/tts", `{"text":"hello","format":"pcm_s16le"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	peak := 0
+	pcm := w.Body.Bytes()
+	for i := 0; i+1 < len(pcm); i += 2 {
+		peak = max(peak, abs(int(int16(binary.LittleEndian.Uint16(pcm[i:])))))
+	}
+	if peak < 1000 {
+		t.Errorf("peak sample %d, want audible audio", peak)
+	}
+}
+
+func TestTTSHandlerErrors(t *testing.T) {
+	useFakeBackend(t)
+	useVoices(t, "kore")
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+	}{
+		{"method", http.MethodGet, "", http.StatusMethodNotAllowed},
+		{"bad json", http.MethodPost, `{"text":`, http.StatusBadRequest},
+		{"empty text", http.MethodPost, `{"text":"   "}`, http.StatusBadRequest},
+		{"unknown voice", http.MethodPost, `{"text":"hi","voice":"nobody"}`, http.StatusBadRequest},
+		{"unknown format", http.MethodPost, `{"text":"hi","format":"flac"}`, http.StatusBadRequest},
+		{"too long", http.MethodPost, `{"text":"` + strings.Repeat("a", maxTextLen+1) + `"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(ttsHandler, tt.method, "/tts", tt.body); w.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+
+	if w := serve(ttsHandler, http.MethodOptions, "/tts", ""); w.Code != http.StatusNoContent {
+		t.Errorf("preflight status %d, want %d", w.Code, http.StatusNoContent)
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
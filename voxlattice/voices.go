@@ -17,20 +17,33 @@ func voicesHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-	names := getSupportedVoiceNames()
+	voices := currentVoices()
+	names := sortedVoiceNames(voices)
 	list := make([]voiceItem, 0, len(names))
 	for _, name := range names {
 		list = append(list, voiceItem{
 			Name:        name,
-			Description: supportedVoices[name],
+			Description: voices[name],
 		})
 	}
 	json.NewEncoder(w).Encode(list)
 }
 
+// currentVoices returns the supported voices by name. The map is shared
+// and must not be modified; a reload replaces it instead.
+func currentVoices() map[string]string {
+	if voices := supportedVoices.Load(); voices != nil {
+		return *voices
+	}
+	return nil
+}
+
 // Helper function to get supported voice names
 func getSupportedVoiceNames() []string {
-	voices := supportedVoices
+	return sortedVoiceNames(currentVoices())
+}
+
+func sortedVoiceNames(voices map[string]string) []string {
 	if voices == nil {
 		return nil
 	}
@@ -207,3 +220,37 @@ func loadSupportedVoices(configDir string) (map[string]string, string, error) {
 	}
 	return voices, "default", nil
 }
+
+// reloadVoices replaces the supported voices with those in path. An
+// unreadable or invalid file leaves the current set in place.
+func reloadVoices(path string) {
+	voices, _, _, err := loadVoicesFromFile(path)
+	if err != nil {
+		appLog.Warnf("Voices.json reload failed, keeping %d voices: %v", len(currentVoices()), err)
+		return
+	}
+	supportedVoices.Store(&voices)
+	appLog.Infof("Voices reloaded: %d from %s", len(voices), path)
+}
+
+// watchVoicesFile reloads the voices whenever the modification time or
+// size of path changes, checking every interval.
+func watchVoicesFile(path string, interval time.Duration) {
+	var last os.FileInfo
+	if info, err := os.Stat(path); err == nil {
+		last = info
+	}
+	go func() {
+		for range time.Tick(interval) {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			if last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+				continue
+			}
+			last = info
+			reloadVoices(path)
+		}
+	}()
+}
@@ -0,0 +1,357 @@
+package voxlattice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsWriteTimeout = 10 * time.Second
+	wsQueueLen     = 64
+	// The client must answer pings (browsers do) or send something within
+	// wsPongWait; pings go out often enough to keep a live client in time.
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = wsPongWait * 9 / 10
+	// A connection with nothing queued or spoken for this long is closed.
+	wsIdleTimeout = 5 * time.Minute
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 16 * 1024,
+	// CORS is open on every other endpoint as well
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+// Messages the client sends as JSON text frames
+type wsClientMsg struct {
+	Type string `json:"type"` // "text", "flush" or "close"
+	Text string `json:"text,omitempty"`
+}
+
+// Events the server sends as JSON text frames; audio goes in binary frames
+type wsServerMsg struct {
+	Type        string `json:"type"` // "ready", "sentence", "flushed", "done" or "error"
+	Text        string `json:"text,omitempty"`
+	Message     string `json:"message,omitempty"`
+	Format      string `json:"format,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	SampleRate  int    `json:"sample_rate,omitempty"`
+}
+
+// wsConn serializes writes from the reader and speaker goroutines and lets
+// encoders write audio as binary messages.
+type wsConn struct {
+	mu   sync.Mutex
+	conn *websocket.Conn
+}
+
+func (c *wsConn) send(msg wsServerMsg) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return c.conn.WriteJSON(msg)
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if err := c.conn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *wsConn) ping() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+}
+
+// close sends a close frame with code and reason, then drops the
+// connection so a blocked read returns.
+func (c *wsConn) close(code int, reason string) {
+	c.mu.Lock()
+	_ = c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(wsWriteTimeout))
+	c.mu.Unlock()
+	_ = c.conn.Close()
+}
+
+// streamItem is a sentence to speak, or an event to send once everything
+// queued before it has been spoken.
+type streamItem struct {
+	text  string
+	event string
+}
+
+// ttsStreamHandler serves /tts/stream: text fragments come in, are buffered
+// until a sentence is complete and spoken through one long-lived session,
+// and the audio goes back as binary frames.
+func ttsStreamHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	voice := strings.ToLower(strings.TrimSpace(q.Get("voice")))
+	if voice != "" {
+		if _, exists := currentVoices()[voice]; !exists {
+			http.Error(w, fmt.Sprintf("unsupported voice: %s, supported voices: %v", voice, getSupportedVoiceNames()), http.StatusBadRequest)
+			return
+		}
+	}
+
+	model, err := resolveModel(q.Get("model"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	out := ttsReq{Format: q.Get("format")}
+	if out.Format == "" {
+		out.Format = "pcm_s16le"
+	}
+	if v := q.Get("sample_rate"); v != "" {
+		if out.SampleRate, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "invalid sample_rate", http.StatusBadRequest)
+			return
+		}
+	}
+	format, opts, err := resolveOutput(r, out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !format.raw {
+		http.Error(w, "stream format must be one of: pcm_s16le, mulaw, alaw", http.StatusBadRequest)
+		return
+	}
+
+	// Browsers cannot set headers on a WebSocket handshake, so the key may
+	// also come as a query parameter.
+	auth, err := resolveAPIKey(r, q.Get("key"))
+	if err == nil {
+		err = auth.checkAccess(model, voice)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), authErrorStatus(err))
+		return
+	}
+	// The connection is one request; its text is charged as it arrives
+	adm, err := admitRequest(r, auth, "")
+	if err != nil {
+		setRetryAfter(w, err)
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	defer adm.done()
+	clientID, limits := auth.clientID(r), auth.limits()
+	// refund gives back the characters of text that was charged but never
+	// spoken, because it failed or the connection went away first.
+	refund := func(text string) {
+		if usage != nil {
+			usage.refund(clientID, int64(utf8.RuneCountInString(text)), time.Now())
+		}
+	}
+	noteSpeech(r, voice, "")
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied with an HTTP error
+		reqLog(r).Debugf("tts stream upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(maxTextLen + 1024)
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	ws := &wsConn{conn: conn}
+
+	// The request's context may end with the upgrade; keep only its trace
+	ctx, cancel := context.WithCancel(detachedContext(context.Background(), r.Context()))
+	defer cancel()
+
+	sreq := synthRequest{Voice: voice, Lang: q.Get("lang"), Model: model, APIKey: auth.apiKey}
+	session, err := openSpeechSession(ctx, sreq)
+	if err != nil {
+		_ = ws.send(wsServerMsg{Type: "error", Message: err.Error()})
+		return
+	}
+	defer session.Close()
+
+	enc, err := format.Stream(ws, opts)
+	if err != nil {
+		_ = ws.send(wsServerMsg{Type: "error", Message: err.Error()})
+		return
+	}
+	emit := func(pcm []byte) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := enc.Write(pcm); err != nil {
+			cancel()
+			return err
+		}
+		return nil
+	}
+
+	if err := ws.send(wsServerMsg{Type: "ready", Format: format.name, ContentType: format.mediaType(opts), SampleRate: opts.sampleRate}); err != nil {
+		return
+	}
+
+	// pending counts queued items not yet spoken; lastActive is when the
+	// client last sent something or the last item finished.
+	var pending atomic.Int64
+	var lastActive atomic.Int64
+	lastActive.Store(time.Now().UnixNano())
+
+	// Keep the connection alive with pings and close it once it has been
+	// idle too long or has run for maxRequestTimeout; closing ends the read
+	// loop below, which cancels whatever is still being spoken.
+	go func() {
+		ticker := time.NewTicker(wsPingPeriod)
+		defer ticker.Stop()
+		lifetime := time.NewTimer(maxRequestTimeout)
+		defer lifetime.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-lifetime.C:
+				reqLog(r).Infof("tts stream closed after %v", maxRequestTimeout)
+				ws.close(websocket.ClosePolicyViolation, fmt.Sprintf("session longer than %v", maxRequestTimeout))
+				return
+			case <-ticker.C:
+				if pending.Load() == 0 && time.Since(time.Unix(0, lastActive.Load())) > wsIdleTimeout {
+					reqLog(r).Debugf("tts stream idle for %v, closing", wsIdleTimeout)
+					ws.close(websocket.CloseNormalClosure, "idle timeout")
+					return
+				}
+				if err := ws.ping(); err != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	queue := make(chan streamItem, wsQueueLen)
+	spoken := make(chan struct{})
+	go func() {
+		defer close(spoken)
+		for item := range queue {
+			if ctx.Err() != nil {
+				refund(item.text)
+				continue
+			}
+			if item.text != "" {
+				if err := ws.send(wsServerMsg{Type: "sentence", Text: item.text}); err != nil {
+					cancel()
+					refund(item.text)
+					continue
+				}
+				sreq := sreq
+				sreq.Text = item.text
+				spanCtx, observed, done := observeUpstream(ctx, model, emit, speechAttrs(sreq)...)
+				err := session.speak(spanCtx, item.text, observed)
+				done(err)
+				if err != nil {
+					refund(item.text)
+				}
+				if err != nil && ctx.Err() == nil {
+					countRequestError(r, err)
+					reqLog(r).Warnf("tts stream sentence failed: %v", err)
+					_ = ws.send(wsServerMsg{Type: "error", Message: err.Error()})
+				}
+			}
+			if item.event != "" {
+				if item.event == "done" {
+					_ = enc.Close()
+				}
+				_ = ws.send(wsServerMsg{Type: item.event})
+			}
+			lastActive.Store(time.Now().UnixNano())
+			pending.Add(-1)
+		}
+	}()
+
+	// enqueue adds sentences of text to the queue, skipping anything that
+	// normalizes to nothing.
+	enqueue := func(sentences ...string) {
+		for _, s := range sentences {
+			if text, err := normalizeText(s); err == nil {
+				pending.Add(1)
+				queue <- streamItem{text: text}
+			}
+		}
+	}
+
+	var buf string
+	closed := false
+	for !closed {
+		// Set before each read rather than after: enqueueing can block on a
+		// full queue for longer than wsPongWait without the client being gone.
+		_ = conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		kind, data, err := conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				reqLog(r).Debugf("tts stream read ended: %v", err)
+			}
+			cancel()
+			break
+		}
+		lastActive.Store(time.Now().UnixNano())
+		var msg wsClientMsg
+		if kind != websocket.TextMessage || json.Unmarshal(data, &msg) != nil {
+			_ = ws.send(wsServerMsg{Type: "error", Message: "invalid json"})
+			continue
+		}
+		switch msg.Type {
+		case "text":
+			if usage != nil {
+				if err := usage.charge(clientID, limits, int64(utf8.RuneCountInString(msg.Text))); err != nil {
+					_ = ws.send(wsServerMsg{Type: "error", Message: err.Error()})
+					continue
+				}
+			}
+			noteSpeech(r, "", msg.Text)
+			var sentences []string
+			sentences, buf = completeSentences(buf + msg.Text)
+			enqueue(sentences...)
+		case "flush":
+			enqueue(buf)
+			buf = ""
+			pending.Add(1)
+			queue <- streamItem{event: "flushed"}
+		case "close":
+			enqueue(buf)
+			buf = ""
+			pending.Add(1)
+			queue <- streamItem{event: "done"}
+			closed = true
+		default:
+			_ = ws.send(wsServerMsg{Type: "error", Message: fmt.Sprintf("unknown message type: %s", msg.Type)})
+		}
+	}
+	close(queue)
+	<-spoken
+	refund(buf)
+
+	if closed && ctx.Err() == nil {
+		ws.mu.Lock()
+		_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(wsWriteTimeout))
+		ws.mu.Unlock()
+	}
+}